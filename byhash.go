@@ -0,0 +1,54 @@
+package configfilter
+
+import (
+	"strings"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+const byHashID = "by-hash"
+
+// preprocessByHash handles the requests for a version of the routing table
+// identified by its content hash, at /__config/by-hash/<hash>. The hash is the
+// ETag of the GET responses, with or without the quotes, or its part before the
+// dash.
+func preprocessByHash(req request, sub string) (request, error) {
+	hash := strings.Trim(contentETag(sub), `"`)
+	if hash == "" || strings.Contains(hash, "/") {
+		return req, errNotFound
+	}
+
+	if req.method != "GET" && req.method != "HEAD" {
+		return req, errMethodNotSupported
+	}
+
+	// the response contains the complete table, like the root
+	req.id = ""
+	req.byHash = `"` + hash + `"`
+	return req, nil
+}
+
+// getByHash returns the most recent version of the routes retained in the
+// history, whose content hash matches the one in the request.
+func (s *Spec) getByHash(req request) response {
+	for i := len(s.history) - 1; i >= 0; i-- {
+		h := s.history[i]
+		if h.etag != req.byHash {
+			continue
+		}
+
+		routes := append(append([]*eskip.Route(nil), h.routes...), s.defaults...)
+		if req.namespace != "" {
+			routes = routesWithPrefix(h.routes, req.namespace)
+		}
+
+		return response{
+			withContent: true,
+			routes:      routes,
+			etag:        representationETag(h.etag, req.namespace, req.variant),
+			version:     h.version,
+		}
+	}
+
+	return response{err: errNotFound}
+}
@@ -0,0 +1,15 @@
+package configfilter
+
+import "time"
+
+// Clock provides the current time and timers for the data client. It can be
+// replaced in the Options, e.g. for testing.
+type Clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
@@ -0,0 +1,86 @@
+package configfilter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+const versionsID = "versions"
+
+// versionDiff contains the differences between two versions of the routing
+// table. The changed routes are listed both in their previous and next form.
+type versionDiff struct {
+	added, removed, changedFrom, changedTo []*eskip.Route
+}
+
+func (s *Spec) diffVersions(req request) response {
+	if req.diffFrom > s.version || req.diffTo > s.version {
+		return response{err: badRequestString("unknown version")}
+	}
+
+	from, okFrom := s.snapshot(req.diffFrom)
+	to, okTo := s.snapshot(req.diffTo)
+	if !okFrom || !okTo {
+		return response{err: errGone}
+	}
+
+	fromRoutes, toRoutes := from.routes, to.routes
+	if req.namespace != "" {
+		fromRoutes = routesWithPrefix(fromRoutes, req.namespace)
+		toRoutes = routesWithPrefix(toRoutes, req.namespace)
+	}
+
+	d := &versionDiff{
+		added:     removeRoutes(toRoutes, fromRoutes),
+		removed:   removeRoutes(fromRoutes, toRoutes),
+		changedTo: changedRoutes(fromRoutes, toRoutes),
+	}
+
+	d.changedFrom = idsToRoutes(routesToIDs(d.changedTo), fromRoutes)
+	for _, r := range [][]*eskip.Route{d.added, d.removed, d.changedFrom, d.changedTo} {
+		sortByID(r)
+	}
+
+	return response{diff: d, version: s.version}
+}
+
+// writeDiff writes the differences in a line based format, where the added
+// routes are prefixed with +, the removed ones with -, and the changed ones
+// are listed with ~, followed by their previous and next form.
+func writeDiff(w io.Writer, d *versionDiff) error {
+	for _, r := range d.added {
+		if _, err := fmt.Fprintf(w, "+ %s\n", eskip.String(r)); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range d.removed {
+		if _, err := fmt.Fprintf(w, "- %s\n", eskip.String(r)); err != nil {
+			return err
+		}
+	}
+
+	for i, r := range d.changedTo {
+		if _, err := fmt.Fprintf(
+			w,
+			"~ %s\n  - %s\n  + %s\n",
+			r.Id,
+			eskip.String(d.changedFrom[i]),
+			eskip.String(r),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *filter) serveDiff(w http.ResponseWriter, req request, rsp response) {
+	w.Header().Set("Content-Type", contentTypeText)
+	if req.method == "GET" {
+		writeDiff(w, rsp.diff)
+	}
+}
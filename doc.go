@@ -9,6 +9,9 @@
 // for accessing all the routes, and one for accessing individual routes with their ID. For individual routes,
 // the routing needs to include the :routeid wildcard in the path predicate.
 //
+// The same API can be served outside of Skipper, too, with the http.Handler returned by Spec.Handler. The routes
+// can be also polled from an external source, see PollSource.
+//
 // See the value of the APIDescription constant for the API description.
 package configfilter
 
@@ -21,7 +24,36 @@ settings where the root path for the config is /__config and the individual rout
 /__config/<routeid>.
 
 In all requests, changes to the default routes that the config filter was initialized with, typically containing
-the routes with the config filter itself, are ignored.
+the routes with the config filter itself, are ignored. When a request tries to modify or delete default routes,
+their IDs are listed in the X-Ignored-Defaults response header, separated by commas.
+
+When a PUT, POST, PATCH or DELETE request accepts JSON, the response contains the count of the added, updated
+and deleted routes, e.g. {"added": 2, "updated": 1, "deleted": 0}.
+
+When enabled in the options, the PUT, POST, PATCH and DELETE requests must contain the hex encoded HMAC-SHA256
+signature of the request body in the X-Signature header, e.g. X-Signature: sha256=<signature>. The requests with
+a missing or invalid signature are rejected with 401. The GET requests creating an individual route with
+?createIfMissing need to carry the signature of the value of the createIfMissing query parameter.
+
+When enabled in the options, POST requests with the X-HTTP-Method-Override header are handled with the method
+set in the header, e.g. X-HTTP-Method-Override: DELETE.
+
+The responses are pretty printed, unless the data client was configured to be compact by default. The query
+parameter ?pretty=true or ?pretty=false overrides the default in each request.
+
+When the request contains the Accept-Encoding: gzip header, the response body is compressed and the response
+contains the Content-Encoding: gzip header. The Content-Type header tells the format of the decompressed body.
+
+The request and response bodies are encoded in UTF-8, and the textual responses contain the charset=utf-8
+parameter in the Content-Type header. Requests with invalid UTF-8 content are rejected with 400.
+
+When a request contains the Content-Transfer-Encoding: base64 header, its body is decoded before it is parsed.
+Invalid base64 content is rejected with 400.
+
+The config filter in the self routes accepts optional arguments: config("readonly") rejects the PUT, POST,
+PATCH and DELETE requests, and the GET requests with ?createIfMissing, with 405, and
+config("namespace", "<namespace>") serves only the routes in the namespace, as described in the Namespaces
+section.
 
 ### Root - All routes
 
@@ -33,21 +65,159 @@ HEAD: returns the header of the responses sent to the GET request
 GET:
 
 Get all route definitions maintined by the configfilter data client in eskip format. If the query parameter
-?pretty=false is set, pretty printing is omitted.
+?pretty=false is set, pretty printing is omitted. If the query parameter ?order=topo is set, the routes that are
+the targets of loopback routes are returned before the routes referencing them, otherwise ordered by their ID.
+If the query parameter ?effectiveOrder=true is set, the routes are returned in the order that the routing would
+try to match them, as far as it can be told from the predicates: the routes with static paths before the ones
+with wildcards, and, with the same path, the ones with more predicates first. If the query parameter
+?sort=<id|backend|predicates> is set, the routes are sorted by their ID, by their backend, or by the count of
+their predicates, and the routes with the same backend or count of predicates by their ID. If the query
+parameter ?expand=true is set, the loopback routes in the eskip response are preceded by comments containing the
+routes that they pass the requests on to, following the chains of loopback routes, looked up only in the
+enabled routes of the same namespace. If the query parameter ?sections=byPrefix is set, the eskip response
+groups the routes by the part of their ID before the first underscore, with a comment header naming each group,
+and the routes without such prefix in the last group, named other.
+If the query parameter ?analyze=shadowing is set, the response contains, instead of the routes, the routes that
+can never match, because another route matches every request that they would match, one per line in the format
+of <id> shadowed by <id>, or, when JSON is accepted, as an array of objects with the id and shadowedBy fields.
+The check is strict: since the routing has no defined order for the routes with the same weight, a route is
+reported only when an earlier route has the same matcher, with the same path, ignoring the names of the
+wildcards, the same methods, hosts and headers, and the same other predicates. The disabled routes are not
+analyzed.
+If the query parameter ?backendType=<network|shunt|loopback> is set, only the routes with the given type of
+backend are returned. If the query parameter ?method=<method> is set, only the routes accepting the HTTP method
+are returned, where the routes without a Method or Methods predicate accept all the methods. If the query
+parameter ?q=<text> is set, only the routes are returned whose expression, including the ID, contains the text,
+ignoring case. If the query parameter ?redact=backends is set, the network backend addresses are replaced with
+REDACTED in the response, and if ?redact=credentials is set, only the credentials in the backend addresses are
+replaced.
+
+The routes can be listed in pages by setting the query parameter ?limit=<n>. The pages are ordered by route ID,
+or, when the order, the effectiveOrder or the sort query parameter is set, in the requested order, and then when
+the route of the cursor was deleted since the previous page, the response has the status 400.
+When there are more routes, the response contains the X-Config-Next-Cursor header, whose value can be passed in
+the ?cursor=<cursor> query parameter, together with the limit, to get the next page.
+
+The response contains an ETag header that depends only on the routes set through the API and on the requested
+representation, the format, the namespace and the query parameters, and it doesn't change as long as the routes
+are the same. The ETag of the representations other than the default one has the content hash of the routes
+before a dash. When the request contains the If-None-Match header with the current ETag, the response has the
+status 304 Not Modified. When the ETag is not the current one, and the query parameter ?delta=true is set, the
+response contains only the routes changed since the version with the ETag, and the IDs of the deleted routes
+in the X-Config-Deleted-IDs header, like with ?sinceVersion, marked with the X-Config-Delta: true header. If the
+ETag is not found in the history, the complete routing table is returned.
+
+Every change of the routing table creates a new version. The current version is returned in the X-Config-Version
+header, also in the responses to the PUT, POST, PATCH and DELETE requests. When the query parameter
+?sinceVersion=<version> is set, only the routes changed since that version are returned, and the IDs of the
+routes deleted since then are listed in the X-Config-Deleted-IDs header, separated by commas. If the version is
+not retained in the history anymore, the response has the status 410 Gone.
+
+When a PUT, POST, PATCH or DELETE request contains the Idempotency-Key header, the result of the successful
+change is kept for a configurable time, and the retries with the same key get the same response, without
+applying the change again. The keys are scoped to the namespace, and when a key is reused for a different
+change, the response has the status 422 Unprocessable Entity.
+
+Every update queued for the routing gets a sequence number, increasing with every update. The responses to the
+changes return it in the X-Config-Sequence header, and the data client logs it, at debug level, when the routing
+receives the update.
+
+When enabled in the options, and the query parameter ?raw=true is set, the last document applied with PUT or
+POST is returned as it was sent. Any other change of the routes discards the document, and then the response
+has the status 404. The raw document is not available in the namespaces, and it cannot be combined with
+?redact.
+
+If the query parameter ?format=zip is set, the routes are returned as a zip archive, containing a separate eskip
+file for each route, named by the route ID.
+
+If the query parameter ?format=skipper-json is set, the routes are returned in the JSON representation of the
+Skipper eskip routes, as encoded by the Go standard library, and the same format is expected in the request
+document of the changes, to exchange the routes with other Skipper tooling.
+
+When the Accept header contains application/json or text/json, or the query parameter ?format=json is set, the
+routes are returned as a JSON array, where each route has the fields id, predicates, filters and backend, and
+the createdAt and updatedAt timestamps. The default routes report the start time of the data client. The
+read-only weight field approximates the priority of the route among the routes with the same path, based on the
+number of its predicates. The read-only lastModifiedBy field contains the user name from the basic authorization
+of the request that last changed the route, and it is omitted when the request had no basic authorization. The
+JSON options can make the field names snake_case, e.g. created_at, and omit all the empty fields. When the
+options contain a traffic provider, and the query parameter ?withTraffic=true is set, the routes in JSON contain
+the traffic field, with the number of the requests handled by the route, and their mean latency in milliseconds,
+e.g. "traffic": {"requests": 42, "latency": 12.5}. The routes without statistics have no traffic field.
+
+The responses containing the routes end with the X-Config-Complete trailer, set to true when the complete body
+was written, and to false when writing it failed, so that the clients can detect the truncated responses. The
+trailer is not necessarily forwarded by the proxies.
+
+When the Accept header contains text/html, and none of the other supported types, the routes are rendered as
+an HTML table, for inspecting them in a browser.
 
 PUT and POST:
 
-Set the complete routing table. Expects route definitions in eskip format, as text/plain or application/eskip.
-Routes missing form the request document and existing in the current routing table will be deleted.
+Set the complete routing table. Expects route definitions in eskip format, as text/plain or application/eskip,
+or uploaded as files in multipart/form-data, where the content of the files is concatenated. It also accepts
+routes in JSON, as application/json, in the same format as returned by GET. When the request has no Content-Type
+header, documents starting with [ or { are parsed as JSON, otherwise as eskip. When enabled in the options, the
+rejected eskip documents are parsed again, after dropping the redundant semicolons and the commas before closing
+parentheses. Routes missing from the request document and existing in the current routing table will be deleted.
+
+With the query parameter ?format=tar, or the content type application/x-tar, the request body is expected to be
+a tar archive, where each file with the .eskip extension contains one or more routes, and the other entries are
+ignored. Malformed archives, and the ones without .eskip files, are rejected with 400. With PATCH, the routes in
+the archive are merged into the existing ones, otherwise they replace all the routes.
 
-PATCH: Upsert routes in the routing table. It is like PUT or POST but not deleting existing routes.
+When the files in multipart/form-data are uploaded with the query parameter
+?merge=<overlay-wins|base-wins|error-on-conflict>, each file is merged into the previous ones as an overlay.
+Routes with the same ID but different definitions are taken from the overlay or the base, or, with
+error-on-conflict, the request is rejected with 409, listing the conflicting IDs.
+
+When the document contains routes without an ID, or different routes with the same ID, all the problems are
+returned together in the response with the status 400 Bad Request, as a JSON array when JSON is accepted, or as
+plain text, one problem per line. When the document parses, but a route has a network backend that is not an
+absolute HTTP URL, or, with the FilterRegistry option, a filter unknown to the registry, the problems are
+returned in the same way, with the status 422 Unprocessable Entity. When the options restrict the allowed
+predicates, the routes using other predicates are rejected with 400, naming the predicate and the route.
+Similarly, when the options contain validators for the filter arguments, the routes with invalid filter
+arguments are rejected with 400, also with the filters added by PATCH. When a table validation is set in the
+options, and it rejects the complete routing table resulting from the request, the previous table is kept, and
+the response has the status 422. When enabled in the options, the routes with contradicting predicates, that can
+never match, are rejected with 400, too. The IDs applied, debug, ids, match, metrics, ns, propagation, schema,
+status, trash, txn and versions are reserved for the endpoints of the API, and the routes with these IDs are
+rejected with 400.
+When a duplicate suppression window is set in the options, and the same document was applied within the
+window, it is not applied again, and the response has the status 304 Not Modified.
+
+PATCH:
+
+Upsert routes in the routing table. It is like PUT or POST but not deleting existing routes.
+
+When the content is a JSON array, it is interpreted as a list of changes to existing routes, where each item
+has the id field, and optionally the backend, the addFilters, the removeFilters and the disabled fields, e.g:
+
+	[{"id": "foo", "backend": "https://foo.example.org", "removeFilters": ["setPath"]}]
+
+The response contains a JSON array with the status of each change, where the changes to missing routes have
+the status 404. If any of the changes failed, the response has the status 207 Multi-Status. The changed
+routes are validated the same way as the routes sent in the request documents, and when any of them is invalid,
+none of the changes are applied.
 
 DELETE:
 
 Deletes routes by ID found in the request payload. Accepts eskip documents with content type text/plain or
-application/eskip, where only the ID is used, or it accepts a comma separated list of IDs. IDs that are not
+application/eskip, where only the ID is used, or it accepts a comma separated list of IDs. The separator of the
+IDs can be changed in the options, e.g. to a newline or a semicolon. IDs that are not
 found in the current routing table are ignored. Routes in the default configuration of the filter are not
-deleted.
+deleted. When the query parameter ?olderThan=<duration> is set, e.g. ?olderThan=24h, the routes that were not
+created or updated within the specified duration are deleted, too, where the initial routes count as created
+when the data client was started. When the query parameter ?strict=true is set,
+and any of the IDs is not found, no route is deleted, and the response has the status 404 Not Found, listing
+the IDs that were not found. When the query parameter ?matchBody=true is set, the routes are deleted only if the
+submitted eskip expressions are identical to the stored ones, otherwise no route is deleted, and the response has
+the status 409 Conflict, listing the IDs of the changed routes.
+
+When the query parameter ?previewResult=true is set for PUT, POST, PATCH or DELETE, either on the root or on an
+individual route, the change is not applied, and the response contains the complete routing table as it would
+be after the change, in the same format as the response of GET.
 
 ### Individual routes
 
@@ -59,14 +229,179 @@ HEAD: returns the header of the responses sent to the GET request
 GET:
 
 Returns the route as a route expression with ID=<routeid>, without the ID. If the query parameter ?pretty=false
-is set, pretty printing is omitted.
+is set, pretty printing is omitted. If the query parameter ?createIfMissing=<expression> is set, where the
+expression is a base64 encoded route expression, and the route doesn't exist, the route is created from the
+expression, and returned with 201. The individual routes are read from a copy published after every change,
+without waiting for the changes being processed, e.g. a long update of the whole table. The changes, including
+the ones of the individual routes, are applied one at a time, in the order of the requests, because every
+change creates a new version of the whole routing table.
 
 PUT and POST:
 
 Set the route with ID=<routeid>. Expects a single route expression in eskip format. If the payload contains a
 route ID, it is ignored, and the ID derived from the path is used. If the route doesn't exist, it gets inserted,
-if it exists, it gets updated.
+if it exists, it gets updated. If the query parameter ?requires=<otherid> is set, the route is set only when a
+route with the other ID exists, otherwise the response has the status 409. When enabled in the options, setting a
+route identical to the stored one is responded with 304 Not Modified, and so are the other changes that leave the
+routes as they are. When enabled in the options, the route IDs are normalized to lowercase, both in the paths and
+in the documents, so that e.g. /__config/Foo and /__config/foo refer to the same route.
+
+PATCH:
+
+Updates a route if it exists, merging the route expression in the request into the existing route: the
+predicates and the filters with the same name are replaced, the new ones are added, and the ones not mentioned
+are kept. When the route has multiple predicates or filters with the same name, e.g. setRequestHeader, they are
+told apart by their first argument. The backend is taken from the request. PUT and POST, in contrast, replace
+the whole route.
+
+When the content is a JSON object with the removeFilter field, e.g. {"removeFilter": "ratelimit"}, all the
+filters with the given name are removed from the route.
+
+When the content is a JSON object with the disabled field, e.g. {"disabled": true}, the route is disabled or
+enabled. A disabled route is kept in the routing table, and returned by the API, with the disabled field set in
+JSON, or preceded by the // disabled comment in eskip, but it is not passed to the routing. Disabling or
+enabling a route changes the ETag of the routing table.
+
+When the content is a JSON object with the test and the set fields, e.g.
+{"test": {"backend": "https://old.example.org"}, "set": {"backend": "https://new.example.org"}}, the backend
+or the disabled field in set is applied only if the route currently has the values in test, otherwise the
+response has the status 412 Precondition Failed. The changes sent to the root in a JSON array accept the test
+field, too, and the failed conditions are reported with the status 412.
 
-PATCH: Updates a route if it exists. 
 DELETE: Deletes a route if it exists.
+
+When the X-Route-TTL header is set in PUT, POST or PATCH requests, e.g. X-Route-TTL: 15m, the route is deleted
+automatically when the specified duration has elapsed.
+
+### Rename
+
+Path: /__config/<routeid>/rename
+
+POST: changes the ID of the route to the one set in the query parameter ?to=<newid>, or, when the parameter is
+missing, in the request body. The old route is removed and the renamed one is added in a single update of the
+routing. When no route exists with the old ID, it responds with 404, and when a route already exists with the
+new ID, it responds with 409. The renamed route keeps its creation time, its TTL and, when disabled, stays
+disabled. Default routes cannot be renamed.
+
+### Namespaces
+
+Path: /__config/ns/<namespace>
+
+The namespaces are served like the root, but only the routes whose ID starts with <namespace>__ are returned,
+and PUT and POST replace only these routes, leaving the rest of the routing table unchanged. The IDs are
+prefixed and stripped automatically, e.g. the route foo set in the namespace team gets the ID team__foo.
+
+### Version diff
+
+Path: /__config/versions/diff
+
+GET: requires the query parameters ?from=<version>&to=<version>, and returns the differences between two
+versions retained in the history, as plain text. The added routes are listed with the prefix +, the removed
+ones with -, and the changed ones with ~, followed by their previous and next form. When a version is not
+retained anymore, it responds with 410.
+
+Path: /__config/by-hash/<hash>
+
+GET: returns the routing table with the content hash, as in the ETag header of the responses to GET on the root,
+with or without the quotes, or with the part after the dash, in the same formats as the root. The hash can be
+used as a cache key for distributing the configuration. When no version with the hash is retained in the history,
+it responds with 404.
+
+### Transactions
+
+Path: /__config/txn/begin
+
+POST: starts a transaction, and returns its token as a JSON object, e.g. {"token": "<token>"}, and in the
+X-Config-Transaction header.
+
+Path: /__config/txn/<token>
+
+POST: stages the routes in the request body, accepting the same content as the root. The staged routes are not
+visible, and not passed to the routing, until the transaction is committed. Routes staged with the same ID
+replace the earlier ones.
+
+Path: /__config/txn/<token>/commit and /__config/txn/<token>/abort
+
+POST: commit applies all the staged routes at once, like PATCH to the root, while abort discards them. The
+transactions not committed within the configured timeout are discarded. Unknown or discarded transactions are
+responded with 404.
+
+### Propagation
+
+Path: /__config/propagation/pause and /__config/propagation/resume
+
+POST: pause stops passing the changes to the routing, while the API keeps accepting and recording them, e.g. to
+batch many changes during a migration. Resume passes all the changes made during the pause to the routing as a
+single update. While paused, the routing loading all the routes receives the routes from the time of the pause.
+
+### Trash
+
+Path: /__config/trash
+
+When enabled in the options, the routes deleted with DELETE requests are kept in the trash, up to a configured
+number of routes and age. When enabled, the route with the ID trash cannot be accessed individually.
+
+GET: returns the routes in the trash, in the same formats as the root.
+
+Path: /__config/trash/<routeid>/restore
+
+POST: restores the route from the trash. When the route is not in the trash, it responds with 404, and when a
+route already exists with the same ID, it responds with 409.
+
+### IDs
+
+Path: /__config/ids
+
+GET: returns the IDs of all the routes, including the default routes, as a JSON array when JSON is accepted,
+otherwise as plain text, one ID per line. The route with the ID ids cannot be accessed individually.
+
+### Schema
+
+Path: /__config/schema
+
+GET: returns the JSON Schema of the routes accepted in JSON, either a single route object or an array of them.
+The read-only fields are accepted, but ignored, including the disabled field, that can be changed only with
+PATCH. The route with the ID schema cannot be accessed individually.
+
+### Match
+
+Path: /__config/match?path=<path>&method=<method>&host=<host>
+
+GET: returns the routes that would match a request with the path, method and host, in the order that the
+routing would try them, in the same formats as the root. The method defaults to GET. Only the path, the host and
+the method predicates are evaluated, and the routes with other predicates, e.g. headers, are not returned,
+neither are the disabled routes. The route with the ID match cannot be accessed individually.
+
+### Applied
+
+Path: /__config/applied
+
+GET: requires the query parameter ?version=<version>, where the version is typically taken from the
+X-Config-Version header of a response to a PUT, POST, PATCH or DELETE request. It returns a JSON object, where
+the applied field tells whether the data client has already delivered the routing table up to that version to
+the routing. The route with the ID applied cannot be accessed individually.
+
+### Status
+
+Path: /__config/status
+
+GET: returns a JSON object, where the degraded field tells whether the last update delivered to the routing
+carried an error, e.g. because the routing missed an update. In this case, the response status is 503, and the
+lastError field contains the error. The data client recovers when the routing reloads all the routes. The route
+with the ID status cannot be accessed individually.
+
+### Metrics
+
+Path: /__config/metrics
+
+GET: returns the number of the routes, the number of the API requests by method, and the time of the last
+change, in the Prometheus text format. The route with the ID metrics cannot be accessed individually.
+
+### Debug
+
+Path: /__config/debug
+
+GET: when enabled in the options, returns the internal state of the data client in JSON, e.g. whether an update
+is pending to be consumed by the routing. When enabled, the route with the ID debug cannot be accessed
+individually.
 `
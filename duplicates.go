@@ -0,0 +1,45 @@
+package configfilter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// documentHash returns the hash of a complete routing table document, sent
+// to the root, or to the root of a namespace.
+func documentHash(req request) string {
+	h := sha256.New()
+	h.Write([]byte(req.namespace + "\n"))
+	h.Write(req.body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *Spec) suppressesDuplicates(req request) bool {
+	return s.dupWindow > 0 &&
+		req.id == "" &&
+		(req.method == "PUT" || req.method == "POST") &&
+		req.body != nil &&
+		req.txn == "" &&
+		!req.previewResult
+}
+
+// isDuplicate tells whether the same document was applied within the
+// suppression window, and the routes were not changed since then.
+func (s *Spec) isDuplicate(req request) bool {
+	return s.suppressesDuplicates(req) &&
+		documentHash(req) == s.lastDoc &&
+		s.version == s.lastDocVer &&
+		s.clock.Now().Sub(s.lastDocApplied) < s.dupWindow
+}
+
+// recordDocument stores the hash of the applied document, when it was applied,
+// and the version that it resulted in.
+func (s *Spec) recordDocument(req request, rsp response) {
+	if !s.suppressesDuplicates(req) || rsp.err != nil {
+		return
+	}
+
+	s.lastDoc = documentHash(req)
+	s.lastDocApplied = s.clock.Now()
+	s.lastDocVer = s.version
+}
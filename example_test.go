@@ -58,5 +58,8 @@ func Example() {
 	//   -> <shunt>;
 	// __config__singleRoute: Path("/__config/:routeid")
 	//   -> config()
+	//   -> <shunt>;
+	// __config__subresource: Path("/__config/:routeid/*subpath")
+	//   -> config()
 	//   -> <shunt>
 }
@@ -0,0 +1,127 @@
+package configfilter
+
+import (
+	"io"
+	"strings"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+// loopbackTarget is a route that a loopback route passes the requests on to,
+// directly, or, with a depth > 1, through other loopback routes.
+type loopbackTarget struct {
+	depth int
+	route *eskip.Route
+}
+
+// expandLoopbacks returns the chains of the targets of the loopback routes,
+// looked up in the other routes, by the ID of the loopback routes. The cycles
+// are followed only to the first repeated route.
+func expandLoopbacks(routes, all []*eskip.Route) map[string][]loopbackTarget {
+	var expand func([]loopbackTarget, *eskip.Route, int, map[*eskip.Route]bool) []loopbackTarget
+	expand = func(t []loopbackTarget, from *eskip.Route, depth int, visited map[*eskip.Route]bool) []loopbackTarget {
+		visited[from] = true
+		for _, to := range all {
+			if !references(from, to) {
+				continue
+			}
+
+			t = append(t, loopbackTarget{depth: depth, route: to})
+			if !visited[to] {
+				t = expand(t, to, depth+1, visited)
+			}
+		}
+
+		return t
+	}
+
+	expanded := make(map[string][]loopbackTarget)
+	for _, r := range routes {
+		if t := expand(nil, r, 1, make(map[*eskip.Route]bool)); len(t) > 0 {
+			expanded[r.Id] = t
+		}
+	}
+
+	return expanded
+}
+
+// mapLoopbackTargets applies the changes of the returned routes, like removing
+// the ID prefix or redacting the backends, to the expanded targets, too.
+func mapLoopbackTargets(
+	expanded map[string][]loopbackTarget,
+	mapID func(string) string,
+	mapRoutes func([]*eskip.Route) []*eskip.Route,
+) map[string][]loopbackTarget {
+	if expanded == nil {
+		return nil
+	}
+
+	m := make(map[string][]loopbackTarget)
+	for id, t := range expanded {
+		var routes []*eskip.Route
+		for _, ti := range t {
+			routes = append(routes, ti.route)
+		}
+
+		routes = mapRoutes(routes)
+		for i := range t {
+			m[mapID(id)] = append(m[mapID(id)], loopbackTarget{depth: t[i].depth, route: routes[i]})
+		}
+	}
+
+	return m
+}
+
+func eskipSeparator(pretty bool) string {
+	if pretty {
+		return ";\n\n"
+	}
+
+	return ";\n"
+}
+
+// disabledComment marks the disabled routes in the eskip responses.
+const disabledComment = "// disabled\n"
+
+// hasDisabled tells whether any of the routes is disabled.
+func hasDisabled(meta map[string]routeMeta) bool {
+	for _, m := range meta {
+		if m.disabled {
+			return true
+		}
+	}
+
+	return false
+}
+
+// printExpanded prints the routes in eskip format, preceding the loopback
+// routes with comments containing their targets, and the disabled routes with
+// a comment marking them.
+func printExpanded(
+	pretty bool,
+	routes []*eskip.Route,
+	expanded map[string][]loopbackTarget,
+	meta map[string]routeMeta,
+) string {
+	var s []string
+	for _, r := range routes {
+		var rs string
+		if meta[r.Id].disabled {
+			rs = disabledComment
+		}
+
+		for _, t := range expanded[r.Id] {
+			rs += "// " + strings.Repeat("  ", t.depth-1) + "loops back to " +
+				eskip.Print(false, t.route) + "\n"
+		}
+
+		s = append(s, rs+eskip.Print(pretty, r))
+	}
+
+	return strings.Join(s, eskipSeparator(pretty))
+}
+
+func writeExpanded(w io.Writer, req request, rsp response) error {
+	_, err := w.Write([]byte(printExpanded(req.pretty, rsp.routes, rsp.expanded, rsp.meta)))
+	return err
+}
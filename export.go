@@ -0,0 +1,36 @@
+package configfilter
+
+import (
+	"time"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+// tableCopy returns the complete routing table, the defaults and the routes
+// set through the API, in a new slice. The slices of the Spec are shared with
+// the readers in other goroutines, so they are never appended to.
+func (s *Spec) tableCopy() []*eskip.Route {
+	t := append([]*eskip.Route(nil), s.defaults...)
+	return append(t, s.routes...)
+}
+
+// export calls f periodically with the complete routing table, until the data
+// client is closed.
+func (s *Spec) export(interval time.Duration, f func([]*eskip.Route)) {
+	for {
+		select {
+		case <-s.clock.After(interval):
+		case <-s.stop:
+			return
+		}
+
+		c := make(chan []*eskip.Route)
+		select {
+		case s.table <- c:
+		case <-s.stop:
+			return
+		}
+
+		f(<-c)
+	}
+}
@@ -1,10 +1,23 @@
 package configfilter
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	gdutil "github.com/golang/gddo/httputil/header"
 	"github.com/zalando/skipper/eskip"
@@ -14,10 +27,56 @@ import (
 )
 
 type filter struct {
-	request chan<- request
-	log     logging.Logger
+	request           chan<- request
+	log               logging.Logger
+	clock             Clock
+	authorize         func(*http.Request, string, string) error
+	authorizeRoutes   func(string, []*eskip.Route, []string) error
+	readLimit         *rateLimiter
+	noContent         bool
+	defaults          []*eskip.Route
+	protectSelf       bool
+	idPrefix          string
+	compact           bool
+	override          bool
+	inflight          chan struct{}
+	debug             chan<- (chan<- debugState)
+	applied           chan<- (chan<- appliedState)
+	status            chan<- (chan<- statusState)
+	reads             *routeReads
+	stats             chan<- (chan<- statsState)
+	operations        *operationCounters
+	trash             bool
+	signingKey        []byte
+	lenient           bool
+	registry          filters.Registry
+	responseHeaders   http.Header
+	rejectUnreachable bool
+	idSeparator       string
+	jsonOptions       JSONOptions
+	lowercaseIDs      bool
+	readOnly          bool
+
+	// allowedPreds and deniedPreds restrict the predicates in the received
+	// routes, a nil allowedPreds allows all the predicates not denied
+	allowedPreds map[string]bool
+	deniedPreds  map[string]bool
+
+	// filterArgs validate the arguments of the filters in the received
+	// routes, by the filter name
+	filterArgs map[string]func([]interface{}) error
+
+	traffic TrafficProvider
+
+	// scoped is set when the ID prefix contains a namespace set in the filter
+	// arguments, and only the routes in the namespace are served
+	scoped bool
 }
 
+// allowedMethods is returned in the Allow header of the OPTIONS and the 405
+// responses.
+const allowedMethods = "OPTIONS, HEAD, GET, PUT, POST, PATCH, DELETE"
+
 func validMethod(method string) bool {
 	switch method {
 	case "OPTIONS", "HEAD", "GET", "PUT", "POST", "PATCH", "DELETE":
@@ -27,6 +86,21 @@ func validMethod(method string) bool {
 	}
 }
 
+// isWrite tells whether the request changes the routing, including the GET
+// requests creating a missing route.
+func isWrite(req request) bool {
+	return mutatingMethod(req.method) || req.createIfMissing
+}
+
+func mutatingMethod(method string) bool {
+	switch method {
+	case "PUT", "POST", "PATCH", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
+
 func trimTrailingSlash(path string) string {
 	if len(path) > 1 && path[len(path)-1] == '/' {
 		return path[:len(path)-1]
@@ -41,10 +115,12 @@ func acceptedMime(method string, h http.Header) responseFormat {
 	var f responseFormat
 	for _, ai := range a {
 		switch ai.Value {
-		case "text/json":
+		case "text/json", "application/json":
 			f |= responseFormatJSON
 		case "application/eskip":
 			f |= responseFormatEskip
+		case "text/html":
+			f |= responseFormatHTML
 		}
 	}
 
@@ -55,14 +131,256 @@ func acceptedMime(method string, h http.Header) responseFormat {
 	return f
 }
 
-func requestPretty(pretty string) bool {
+func requestFormat(format string, accept responseFormat) (responseFormat, error) {
+	switch format {
+	case "":
+		return accept, nil
+	case formatZip:
+		return responseFormatZip, nil
+	case formatJSON:
+		return responseFormatJSON, nil
+	case formatSkipper:
+		return responseFormatSkipperJSON, nil
+	default:
+		return responseFormatNone, badRequestString("unsupported format")
+	}
+}
+
+func requestPretty(pretty string, compact bool) bool {
 	pretty = strings.ToLower(pretty)
 	switch pretty {
 	case "false", "0":
 		return false
+	case "true", "1":
+		return true
+	default:
+		return !compact
+	}
+}
+
+func requestOrder(order string) (string, error) {
+	switch order {
+	case "", orderTopological:
+		return order, nil
+	default:
+		return "", badRequestString("unsupported order")
+	}
+}
+
+func requestMerge(method, id, merge string) (string, error) {
+	if id != "" || method == "DELETE" {
+		return "", nil
+	}
+
+	switch merge {
+	case "", mergeOverlayWins, mergeBaseWins, mergeErrorOnConflict:
+		return merge, nil
+	default:
+		return "", badRequestString("unsupported merge strategy")
+	}
+}
+
+func requestBackend(backend string) (string, error) {
+	switch backend {
+	case "", backendNetwork, backendShunt, backendLoopback:
+		return backend, nil
+	default:
+		return "", badRequestString("unsupported backend type")
+	}
+}
+
+func requestRedact(redact string) (string, error) {
+	switch redact {
+	case "", redactBackends, redactCredentials:
+		return redact, nil
+	default:
+		return "", badRequestString("unsupported redaction")
+	}
+}
+
+func requestPage(q url.Values) (int, string, error) {
+	var (
+		limit  int
+		cursor string
+		err    error
+	)
+
+	if l := q.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			return 0, "", badRequestString("invalid limit")
+		}
+	}
+
+	if c := q.Get("cursor"); c != "" {
+		if limit == 0 {
+			return 0, "", badRequestString("cursor without limit")
+		}
+
+		cursor, err = decodeCursor(c)
+		if err != nil {
+			return 0, "", badRequestString("invalid cursor")
+		}
+	}
+
+	return limit, cursor, nil
+}
+
+// requestVariant identifies the representation of the routes requested with
+// the format and the query parameters, so that the different representations
+// have different ETags.
+func requestVariant(format responseFormat, q url.Values) string {
+	f, _ := decideContentType(format)
+	if f == responseFormatText && len(q) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%d?%s", f, q.Encode())
+}
+
+func requestETags(h string) []string {
+	if h == "" {
+		return nil
+	}
+
+	var tags []string
+	for _, t := range strings.Split(h, ",") {
+		t = strings.TrimSpace(t)
+		t = strings.TrimPrefix(t, "W/")
+		tags = append(tags, t)
+	}
+
+	return tags
+}
+
+func requestTTL(method, id, ttl string) (time.Duration, error) {
+	if ttl == "" || id == "" || !canUseContent(method, id) {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d <= 0 {
+		return 0, badRequestString("invalid route TTL")
+	}
+
+	return d, nil
+}
+
+// requestCreateIfMissing parses the route that an individual GET request creates
+// when the route doesn't exist. The route expression is base64 encoded.
+func requestCreateIfMissing(method, id, expression string) ([]*eskip.Route, error) {
+	if expression == "" || id == "" || method != "GET" && method != "HEAD" {
+		return nil, nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(expression)
+	if err != nil {
+		b, err = base64.URLEncoding.DecodeString(expression)
+	}
+
+	if err != nil {
+		return nil, badRequestString("invalid base64 route expression")
+	}
+
+	r, err := eskip.Parse(string(b))
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	if len(r) != 1 {
+		return nil, badRequestString("exactly one route expected")
+	}
+
+	return r, nil
+}
+
+func requestSince(q url.Values) (int, bool, error) {
+	v := q.Get("sinceVersion")
+	if v == "" {
+		return 0, false, nil
+	}
+
+	since, err := strconv.Atoi(v)
+	if err != nil || since < 0 {
+		return 0, false, badRequestString("invalid version")
+	}
+
+	return since, true, nil
+}
+
+func requestStrict(method, id string, q url.Values) (bool, error) {
+	switch strings.ToLower(q.Get("strict")) {
+	case "", "false", "0":
+		return false, nil
+	case "true", "1":
+		if method != "DELETE" || id != "" {
+			return false, badRequestString("strict is supported only when deleting from the root")
+		}
+
+		return true, nil
+	default:
+		return false, badRequestString("invalid value of strict")
+	}
+}
+
+func requestMatchBody(method, id string, q url.Values) (bool, error) {
+	switch strings.ToLower(q.Get("matchBody")) {
+	case "", "false", "0":
+		return false, nil
+	case "true", "1":
+		if method != "DELETE" || id != "" {
+			return false, badRequestString("matchBody is supported only when deleting from the root")
+		}
+
+		return true, nil
 	default:
+		return false, badRequestString("invalid value of matchBody")
+	}
+}
+
+func requestOlderThan(method, id string, q url.Values) (time.Duration, error) {
+	v := q.Get("olderThan")
+	if v == "" {
+		return 0, nil
+	}
+
+	if method != "DELETE" || id != "" {
+		return 0, badRequestString("olderThan is supported only when deleting from the root")
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, badRequestString("invalid age")
+	}
+
+	return d, nil
+}
+
+func preferMinimal(h http.Header) bool {
+	for _, p := range strings.Split(h.Get("Prefer"), ",") {
+		if strings.TrimSpace(p) == "return=minimal" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func acceptsGzip(h http.Header) bool {
+	for _, e := range strings.Split(h.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(e, ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+
+		if len(parts) > 1 && strings.Replace(parts[1], " ", "", -1) == "q=0" {
+			return false
+		}
+
 		return true
 	}
+
+	return false
 }
 
 func canUseContent(method, id string) bool {
@@ -76,170 +394,1624 @@ func canUseContent(method, id string) bool {
 	}
 }
 
-func getContentType(method, id, contentType string) (string, error) {
-	contentType = strings.Split(contentType, ";")[0]
-	switch contentType {
-	case "", "text/plain", "application/eskip":
-		return contentType, nil
+func getContentType(method, id, contentType string) (string, map[string]string, error) {
+	if contentType == "" {
+		return "", nil, nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", nil, errUnsupportedMediaType
+	}
+
+	switch mediaType {
+	case "text/plain", "application/eskip", "application/json", "multipart/form-data", contentTypeTar:
+		return mediaType, params, nil
 	default:
-		return "", errUnsupportedMediaType
+		return "", nil, errUnsupportedMediaType
 	}
 }
 
-func parseContent(method, id, contentType string, content io.Reader) ([]*eskip.Route, []string, error) {
-	b, err := ioutil.ReadAll(content)
+// decodeTransferEncoding decodes the content sent with the
+// Content-Transfer-Encoding: base64 header. The line breaks in the encoded
+// content are ignored.
+func decodeTransferEncoding(encoding string, b []byte) ([]byte, error) {
+	if !strings.EqualFold(strings.TrimSpace(encoding), "base64") {
+		return b, nil
+	}
+
+	d, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(b)), ""))
 	if err != nil {
-		return nil, nil, err
+		return nil, badRequestString("invalid base64 content")
 	}
 
-	s := string(b)
-	r, err := eskip.Parse(s)
-	if err == nil || contentType == "application/eskip" || err != nil && method != "DELETE" {
+	return d, nil
+}
+
+// multipartContent concatenates the files found in a multipart document.
+func multipartContent(b []byte, boundary string) ([]byte, error) {
+	docs, err := multipartDocuments(b, boundary)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(strings.Join(docs, ";\n")), nil
+}
+
+// multipartDocuments returns the files found in a multipart document.
+func multipartDocuments(b []byte, boundary string) ([]string, error) {
+	mr := multipart.NewReader(bytes.NewReader(b), boundary)
+
+	var docs []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+
 		if err != nil {
-			err = badRequest(err)
+			return nil, badRequest(err)
 		}
 
-		return r, nil, err
+		if p.FileName() == "" {
+			continue
+		}
+
+		pb, err := ioutil.ReadAll(p)
+		if err != nil {
+			return nil, badRequest(err)
+		}
+
+		docs = append(docs, string(pb))
+	}
+
+	if len(docs) == 0 {
+		return nil, badRequestString("no file in multipart content")
 	}
 
-	s = strings.Replace(s, " ", "", -1)
-	return nil, strings.Split(s, ","), nil
+	return docs, nil
 }
 
-func (f *filter) preprocessRequest(hreq *http.Request) (request, error) {
-	var req request
+// mergeMultipart parses the files of a multipart document separately, and
+// merges them in order, each file as an overlay of the previous ones.
+func mergeMultipart(contentType, boundary string, b []byte, strategy string, lenient bool) ([]*eskip.Route, error) {
+	if contentType != "multipart/form-data" {
+		return nil, badRequestString("merging requires multipart content")
+	}
 
-	if !validMethod(hreq.Method) {
-		return req, errMethodNotSupported
+	docs, err := multipartDocuments(b, boundary)
+	if err != nil {
+		return nil, err
 	}
 
-	req.method = hreq.Method
-	req.id = hreq.Header.Get("X-Config-RouteID")
-	req.accept = acceptedMime(req.method, hreq.Header)
-	req.pretty = requestPretty(hreq.URL.Query().Get("pretty"))
+	var (
+		merged      []*eskip.Route
+		conflicting []string
+	)
 
-	if canUseContent(req.method, req.id) {
-		contentType, err := getContentType(req.method, req.id, hreq.Header.Get("Content-Type"))
+	for _, d := range docs {
+		r, _, err := parseContent("PUT", "", "", nil, []byte(d), lenient, "")
 		if err != nil {
-			return req, err
+			return nil, err
 		}
 
-		r, i, err := parseContent(req.method, req.id, contentType, hreq.Body)
-		if err != nil {
-			return req, err
-		}
+		var c []string
+		merged, c = mergeDocuments(merged, r, strategy)
+		conflicting = append(conflicting, c...)
+	}
 
-		if req.id == "" {
-			for _, ri := range r {
-				if ri.Id == "" {
-					return req, badRequestString("route without id")
-				}
-			}
-		} else {
-			if len(r) > 1 {
-				return req, badRequestString("no multiple routes allowed")
-			}
+	if strategy == mergeErrorOnConflict && len(conflicting) > 0 {
+		return nil, errConflictingRoutes{ids: conflicting}
+	}
+
+	return merged, nil
+}
+
+func parseContent(
+	method, id, contentType string,
+	params map[string]string,
+	b []byte,
+	lenient bool,
+	separator string,
+) ([]*eskip.Route, []string, error) {
+	if contentType == "multipart/form-data" {
+		var err error
+		if b, err = multipartContent(b, params["boundary"]); err != nil {
+			return nil, nil, err
 		}
 
-		req.routes = r
-		req.ids = i
+		contentType = "application/eskip"
 	}
 
-	return req, nil
-}
+	if contentType == "" && isJSON(b) {
+		contentType = "application/json"
+	}
 
-func (f *filter) serveError(w http.ResponseWriter, err error) {
-	if berr, ok := err.(errBadRequest); ok {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(berr.Error()))
-		return
+	if contentType == "application/json" {
+		r, err := parseJSON(b)
+		if err != nil {
+			return nil, nil, badRequest(err)
+		}
+
+		return r, nil, nil
 	}
 
-	switch err {
-	case errMethodNotSupported:
-		w.WriteHeader(http.StatusMethodNotAllowed)
-	case errNotFound:
-		w.WriteHeader(http.StatusNotFound)
-	case errUnsupportedMediaType:
-		w.WriteHeader(http.StatusUnsupportedMediaType)
-	default:
-		f.log.Error("server error", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	s := string(b)
+	r, err := parseEskip(s, lenient)
+	if err == nil || contentType == "application/eskip" || err != nil && method != "DELETE" {
+		if err != nil {
+			err = badRequest(err)
+		}
+
+		return r, nil, err
 	}
-}
 
-func decideContentType(f responseFormat) (responseFormat, string) {
-	switch {
-	case f&responseFormatJSON != 0:
-		return responseFormatJSON, "text/json"
-	case f&responseFormatEskip != 0:
-		return responseFormatEskip, "application/eskip"
-	default:
-		return responseFormatText, "text/plain"
+	var ids []string
+	for _, id := range strings.Split(s, separator) {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
 	}
+
+	return nil, ids, nil
 }
 
-func writeEskip(w io.Writer, req request, rsp response) error {
-	var s string
-	if req.id == "" {
-		s = eskip.Print(req.pretty, rsp.routes...)
-	} else {
-		s = rsp.routes[0].Print(req.pretty)
+// routeID returns the ID of a received route, taken from the path for the
+// individual routes.
+func routeID(req request, r *eskip.Route) string {
+	if req.id != "" {
+		return req.id
 	}
 
-	_, err := w.Write([]byte(s))
-	return err
+	return r.Id
 }
 
-func writeResponse(w http.ResponseWriter, req request, rsp response) error {
-	f, ct := decideContentType(req.accept)
-	switch f {
-	case responseFormatJSON:
-		w.WriteHeader(http.StatusNotImplemented)
-		return nil
-	default:
-		w.Header().Set("Content-Type", ct)
-		if req.method == "HEAD" {
-			return nil
+// checkRoutesAuthorized passes the parsed routes of a changing request to the
+// authorization callback. The routes of the individual route requests get the
+// ID from the path. The routes changed by deltas are authorized by the delta
+// checks, when the resulting routes are known.
+func (f *filter) checkRoutesAuthorized(req request) error {
+	var (
+		routes     []*eskip.Route
+		deletedIDs []string
+	)
+
+	if req.method == "DELETE" {
+		deletedIDs = append(deletedIDs, req.ids...)
+		for _, r := range req.routes {
+			deletedIDs = append(deletedIDs, routeID(req, r))
+		}
+
+		if req.id != "" && len(deletedIDs) == 0 {
+			deletedIDs = []string{req.id}
 		}
 
-		return writeEskip(w, req, rsp)
+		return f.authorizeRoutes(req.principal, nil, deletedIDs)
 	}
-}
 
-func (f *filter) ServeHTTP(w http.ResponseWriter, hreq *http.Request) {
-	req, err := f.preprocessRequest(hreq)
-	if err != nil {
-		f.serveError(w, err)
-		return
+	if req.deltas != nil {
+		return nil
 	}
 
-	switch req.method {
-	case "OPTIONS":
-		w.Header().Set("Allow", "HEAD, GET, PUT, POST, PATCH")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(APIDescription))
-		return
+	for _, r := range req.routes {
+		c := *r
+		c.Id = routeID(req, r)
+		routes = append(routes, &c)
 	}
 
-	rspChan := make(chan response)
-	req.response = rspChan
-	f.request <- req
-	rsp := <-rspChan
+	return f.authorizeRoutes(req.principal, routes, nil)
+}
 
-	if rsp.err != nil {
-		f.serveError(w, rsp.err)
+// checkSelfPath reports the routes colliding with the path of the default
+// routes, except for the default routes themselves, whose changes are ignored.
+func (f *filter) checkSelfPath(req request) []string {
+	if !f.protectSelf || req.method == "DELETE" {
+		return nil
 	}
 
-	if rsp.withContent {
-		writeResponse(w, req, rsp)
+	var problems []string
+	for _, r := range req.routes {
+		id := routeID(req, r)
+		if r.Path == "" || f.isDefault(id) {
+			continue
+		}
+
+		for _, d := range f.defaults {
+			if d.Path != "" && pathsOverlap(r.Path, d.Path) {
+				problems = append(problems, fmt.Sprintf("path of route %s collides with the default route %s", id, d.Id))
+				break
+			}
+		}
+	}
+
+	return problems
+}
+
+// checkBatch reports the routes without ID and the conflicting routes with the
+// same ID in a document sent to the root. Identical duplicates are accepted.
+func checkBatch(method string, routes []*eskip.Route) []string {
+	var problems []string
+	seen := make(map[string]*eskip.Route)
+	reported := make(map[string]bool)
+	for i, r := range routes {
+		if r.Id == "" && method == "DELETE" {
+			problems = append(problems, fmt.Sprintf(
+				"route without id at position %d, deleting with eskip requires the route id",
+				i+1,
+			))
+
+			continue
+		}
+
+		if r.Id == "" {
+			problems = append(problems, fmt.Sprintf("route without id at position %d", i+1))
+			continue
+		}
+
+		if method == "DELETE" {
+			continue
+		}
+
+		prev, ok := seen[r.Id]
+		if !ok {
+			seen[r.Id] = r
+			continue
+		}
+
+		if !reported[r.Id] && prev.String() != r.String() {
+			problems = append(problems, fmt.Sprintf("conflicting definitions of route %s", r.Id))
+			reported[r.Id] = true
+		}
+	}
+
+	return problems
+}
+
+// checkPredicates reports the predicates not allowed in the routes. The default
+// routes are not checked, their changes are ignored.
+func (f *filter) checkPredicates(req request) []string {
+	if f.allowedPreds == nil && f.deniedPreds == nil {
+		return nil
+	}
+
+	var problems []string
+	for _, r := range req.routes {
+		id := routeID(req, r)
+		if f.isDefault(id) {
+			continue
+		}
+
+		reported := make(map[string]bool)
+		for _, p := range jsonPredicates(r) {
+			if reported[p.Name] {
+				continue
+			}
+
+			if f.deniedPreds[p.Name] || f.allowedPreds != nil && !f.allowedPreds[p.Name] {
+				problems = append(problems, fmt.Sprintf("predicate %s not allowed in route %s", p.Name, id))
+				reported[p.Name] = true
+			}
+		}
+	}
+
+	return problems
+}
+
+// checkFilterArgs reports the filters whose arguments were rejected by the
+// validators, both in the routes and in the filters added by the JSON patches.
+// The default routes are not checked, their changes are ignored.
+func (f *filter) checkFilterArgs(req request) []string {
+	if len(f.filterArgs) == 0 {
+		return nil
+	}
+
+	var problems []string
+	check := func(id, name string, args []interface{}) {
+		validate, ok := f.filterArgs[name]
+		if !ok {
+			return
+		}
+
+		if err := validate(args); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid arguments of filter %s in route %s: %v", name, id, err))
+		}
+	}
+
+	for _, r := range req.routes {
+		id := routeID(req, r)
+		if f.isDefault(id) {
+			continue
+		}
+
+		for _, fi := range r.Filters {
+			check(id, fi.Name, fi.Args)
+		}
+	}
+
+	for _, d := range req.deltas {
+		for _, fi := range d.AddFilters {
+			check(d.ID, fi.Name, fi.Args)
+		}
+	}
+
+	return problems
+}
+
+// checkContent reports the routes with a network backend that is not an
+// absolute HTTP URL, and, when a filter registry is set, the routes with unknown
+// filters. The default routes are not checked, their changes are ignored.
+func (f *filter) checkContent(req request) []string {
+	var problems []string
+	for _, r := range req.routes {
+		id := routeID(req, r)
+		if f.isDefault(id) {
+			continue
+		}
+
+		if backendType(r) == backendNetwork {
+			u, err := url.Parse(r.Backend)
+			if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+				problems = append(problems, fmt.Sprintf("invalid backend of route %s: %s", id, r.Backend))
+			}
+		}
+
+		if f.registry == nil {
+			continue
+		}
+
+		for _, fi := range r.Filters {
+			if _, ok := f.registry[fi.Name]; !ok {
+				problems = append(problems, fmt.Sprintf("unknown filter in route %s: %s", id, fi.Name))
+			}
+		}
+	}
+
+	return problems
+}
+
+// deltaChecks returns the validation of the routes changed by the deltas of a
+// PATCH request. The changed routes are authorized and checked the same way as
+// the routes sent in the PATCH requests, before they are stored.
+func (f *filter) deltaChecks(req request) func([]*eskip.Route) error {
+	format, _ := decideContentType(req.accept)
+	nf := f.namespaced(req.namespace)
+	return func(routes []*eskip.Route) error {
+		routes = nf.unprefixRoutes(routes)
+		if f.authorizeRoutes != nil {
+			if err := f.authorizeRoutes(req.principal, routes, nil); err != nil {
+				return forbidden(err)
+			}
+		}
+
+		creq := request{method: "PATCH", routes: routes}
+		problems := append(f.checkSelfPath(creq), f.checkPredicates(creq)...)
+		problems = append(problems, f.checkFilterArgs(creq)...)
+		problems = append(problems, f.checkUnreachable(creq)...)
+		if len(problems) > 0 {
+			return errInvalidRoutes{problems: problems, json: format == responseFormatJSON}
+		}
+
+		if problems := f.checkContent(creq); len(problems) > 0 {
+			return errInvalidRoutes{
+				problems:      problems,
+				json:          format == responseFormatJSON,
+				unprocessable: true,
+			}
+		}
+
+		return nil
+	}
+}
+
+func (f *filter) isDefault(id string) bool {
+	return len(idsToRoutes([]string{id}, f.defaults)) > 0
+}
+
+func (f *filter) prefixID(id string) string {
+	if id == "" || f.isDefault(id) {
+		return id
+	}
+
+	return f.idPrefix + id
+}
+
+func (f *filter) unprefixID(id string) string {
+	if f.isDefault(id) {
+		return id
+	}
+
+	return strings.TrimPrefix(id, f.idPrefix)
+}
+
+func (f *filter) unprefixIDs(ids []string) []string {
+	var u []string
+	for _, id := range ids {
+		u = append(u, f.unprefixID(id))
+	}
+
+	return u
+}
+
+// prefixRequest applies the ID prefix to the request. The parsed routes are
+// owned by the request, they can be changed in place.
+func (f *filter) prefixRequest(req request) request {
+	if f.idPrefix == "" {
+		return req
+	}
+
+	return mapRequestIDs(req, f.prefixID)
+}
+
+// lowercaseRequest normalizes the IDs in the request to lowercase, except for
+// the IDs of the default routes.
+func (f *filter) lowercaseRequest(req request) request {
+	if !f.lowercaseIDs {
+		return req
+	}
+
+	return mapRequestIDs(req, func(id string) string {
+		if f.isDefault(id) {
+			return id
+		}
+
+		return strings.ToLower(id)
+	})
+}
+
+// mapRequestIDs changes all the route IDs in the request, in place.
+func mapRequestIDs(req request, mapID func(string) string) request {
+	req.id = mapID(req.id)
+	req.rename = mapID(req.rename)
+	req.requires = mapID(req.requires)
+	req.restore = mapID(req.restore)
+	for _, r := range req.routes {
+		r.Id = mapID(r.Id)
+	}
+
+	var ids []string
+	for _, id := range req.ids {
+		ids = append(ids, mapID(id))
+	}
+
+	req.ids = ids
+	for i := range req.deltas {
+		req.deltas[i].ID = mapID(req.deltas[i].ID)
+	}
+
+	return req
+}
+
+// unprefixRoutes returns copies of the routes without the ID prefix. The routes
+// are shared with the data client, so they are not changed in place.
+func (f *filter) unprefixRoutes(routes []*eskip.Route) []*eskip.Route {
+	var u []*eskip.Route
+	for _, r := range routes {
+		c := *r
+		c.Id = f.unprefixID(r.Id)
+		u = append(u, &c)
+	}
+
+	return u
+}
+
+// unprefixResponse strips the ID prefix from the response.
+func (f *filter) unprefixResponse(rsp response) response {
+	if f.idPrefix == "" {
+		return rsp
+	}
+
+	meta := make(map[string]routeMeta)
+	for _, r := range rsp.routes {
+		meta[f.unprefixID(r.Id)] = rsp.meta[r.Id]
+	}
+
+	rsp.routes = f.unprefixRoutes(rsp.routes)
+	rsp.meta = meta
+	rsp.expanded = mapLoopbackTargets(rsp.expanded, f.unprefixID, f.unprefixRoutes)
+	if rsp.diff != nil {
+		rsp.diff = &versionDiff{
+			added:       f.unprefixRoutes(rsp.diff.added),
+			removed:     f.unprefixRoutes(rsp.diff.removed),
+			changedFrom: f.unprefixRoutes(rsp.diff.changedFrom),
+			changedTo:   f.unprefixRoutes(rsp.diff.changedTo),
+		}
+	}
+
+	rsp.deletedIDs = f.unprefixIDs(rsp.deletedIDs)
+	rsp.ignoredDefaults = f.unprefixIDs(rsp.ignoredDefaults)
+	for i := range rsp.patched {
+		rsp.patched[i].ID = f.unprefixID(rsp.patched[i].ID)
+	}
+
+	return rsp
+}
+
+var validID = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// reservedIDs are the IDs of the endpoints served at the paths of the
+// individual routes. The routes with these IDs could not be accessed
+// individually, so they are rejected.
+var reservedIDs = map[string]bool{
+	"applied":     true,
+	"debug":       true,
+	"ids":         true,
+	matchID:       true,
+	"metrics":     true,
+	namespaceID:   true,
+	propagationID: true,
+	schemaID:      true,
+	"status":      true,
+	trashID:       true,
+	txnID:         true,
+	versionsID:    true,
+}
+
+// checkReservedIDs reports the routes with the IDs reserved for the API.
+func checkReservedIDs(req request) []string {
+	if req.method == "DELETE" {
+		return nil
+	}
+
+	var problems []string
+	for _, r := range req.routes {
+		if id := routeID(req, r); reservedIDs[id] {
+			problems = append(problems, fmt.Sprintf("route id %s is reserved for the API", id))
+		}
+	}
+
+	return problems
+}
+
+// preprocessSubresource handles the requests to the paths below the individual
+// routes, e.g. /__config/<routeid>/rename.
+func (f *filter) preprocessSubresource(hreq *http.Request, req request, sub string) (request, error) {
+	if f.trash && req.id == trashID {
+		return preprocessRestore(req, sub)
+	}
+
+	if req.id == versionsID && sub == "diff" {
+		return preprocessDiff(hreq, req)
+	}
+
+	if req.id == propagationID {
+		return preprocessPropagation(req, sub)
+	}
+
+	if req.id == byHashID {
+		return preprocessByHash(req, sub)
+	}
+
+	if req.id == "" || sub != "rename" {
+		return req, errNotFound
+	}
+
+	if req.method != "POST" {
+		return req, errMethodNotSupported
+	}
+
+	to := hreq.URL.Query().Get("to")
+	if to == "" {
+		b, err := ioutil.ReadAll(hreq.Body)
+		if err != nil {
+			return req, err
+		}
+
+		to = strings.TrimSpace(string(b))
+	}
+
+	if !validID.MatchString(to) {
+		return req, badRequestString("invalid route id")
+	}
+
+	if reservedIDs[to] {
+		return req, badRequestString("route id reserved for the API")
+	}
+
+	req.rename = to
+	return req, nil
+}
+
+// preprocessRestore handles the requests to restore a route from the trash, at
+// /__config/trash/<routeid>/restore.
+func preprocessRestore(req request, sub string) (request, error) {
+	parts := strings.Split(sub, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "restore" {
+		return req, errNotFound
+	}
+
+	if req.method != "POST" {
+		return req, errMethodNotSupported
+	}
+
+	req.trash = true
+	req.restore = parts[0]
+	return req, nil
+}
+
+// preprocessDiff handles the requests for the differences between two versions,
+// at /__config/versions/diff?from=<version>&to=<version>.
+func preprocessDiff(hreq *http.Request, req request) (request, error) {
+	if req.method != "GET" && req.method != "HEAD" {
+		return req, errMethodNotSupported
+	}
+
+	q := hreq.URL.Query()
+	from, err := strconv.Atoi(q.Get("from"))
+	if err != nil || from < 0 {
+		return req, badRequestString("invalid version")
+	}
+
+	to, err := strconv.Atoi(q.Get("to"))
+	if err != nil || to < 0 {
+		return req, badRequestString("invalid version")
+	}
+
+	req.diff, req.diffFrom, req.diffTo = true, from, to
+	return req, nil
+}
+
+func (f *filter) preprocessRequest(hreq *http.Request) (request, error) {
+	var req request
+
+	if !validMethod(hreq.Method) {
+		return req, errMethodNotSupported
+	}
+
+	req.method = hreq.Method
+	req.principal, _, _ = hreq.BasicAuth()
+	req.idempotencyKey = hreq.Header.Get("Idempotency-Key")
+	if m := hreq.Header.Get("X-HTTP-Method-Override"); f.override && m != "" && req.method == "POST" {
+		m = strings.ToUpper(m)
+		if !validMethod(m) {
+			return req, errMethodNotSupported
+		}
+
+		req.method = m
+	}
+
+	req.id = hreq.Header.Get("X-Config-RouteID")
+	sub := hreq.Header.Get("X-Config-Subpath")
+	if req.id == namespaceID && sub != "" {
+		if !validID.MatchString(sub) {
+			return req, errNotFound
+		}
+
+		// the namespace is served like the root
+		req.id, req.namespace, sub = "", sub, ""
+	}
+
+	if req.id == txnID && sub != "" {
+		var err error
+		if req.txn, req.txnToken, err = requestTransaction(req.method, sub); err != nil {
+			return req, err
+		}
+
+		// the staged routes are parsed like the ones posted to the root
+		req.id, sub = "", ""
+	}
+
+	req.accept = acceptedMime(req.method, hreq.Header)
+	q := hreq.URL.Query()
+	req.pretty = requestPretty(q.Get("pretty"), f.compact)
+	req.jsonOptions = f.jsonOptions
+
+	formatParam := q.Get("format")
+	var err error
+	req.tar, err = requestTar(req.method, req.id, formatParam)
+	if err != nil {
+		return req, err
+	}
+
+	if req.tar {
+		// the tar format applies to the request content
+		formatParam = ""
+	}
+
+	format, err := requestFormat(formatParam, req.accept)
+	if err != nil {
+		return req, err
+	}
+
+	req.accept = format
+	req.variant = requestVariant(format, q)
+
+	order, err := requestOrder(q.Get("order"))
+	if err != nil {
+		return req, err
+	}
+
+	switch strings.ToLower(q.Get("effectiveOrder")) {
+	case "", "false", "0":
+	case "true", "1":
+		if order != "" {
+			return req, badRequestString("effective order cannot be combined with other orders")
+		}
+
+		order = orderEffective
+	default:
+		return req, badRequestString("invalid value of effectiveOrder")
+	}
+
+	switch s := q.Get("sort"); s {
+	case "":
+	case orderID, orderBackend, orderPredicates:
+		if order != "" {
+			return req, badRequestString("sort cannot be combined with other orders")
+		}
+
+		order = s
+	default:
+		return req, badRequestString("unsupported sort")
+	}
+
+	req.order = order
+
+	req.withTraffic, err = f.requestWithTraffic(req.accept, q)
+	if err != nil {
+		return req, err
+	}
+
+	switch strings.ToLower(q.Get("expand")) {
+	case "", "false", "0":
+	case "true", "1":
+		req.expand = true
+	default:
+		return req, badRequestString("invalid value of expand")
+	}
+
+	req.sections, err = requestSections(q.Get("sections"))
+	if err != nil {
+		return req, err
+	}
+
+	req.analyze, err = requestAnalyze(req.method, req.id, q.Get("analyze"))
+	if err != nil {
+		return req, err
+	}
+
+	switch strings.ToLower(q.Get("delta")) {
+	case "", "false", "0":
+	case "true", "1":
+		req.delta = true
+	default:
+		return req, badRequestString("invalid value of delta")
+	}
+
+	req.backend, err = requestBackend(q.Get("backendType"))
+	if err != nil {
+		return req, err
+	}
+
+	req.routeMethod = strings.ToUpper(q.Get("method"))
+	if req.routeMethod != "" && !validID.MatchString(req.routeMethod) {
+		return req, badRequestString("invalid method")
+	}
+
+	req.query = q.Get("q")
+	if req.id != "" && (req.method == "PUT" || req.method == "POST") {
+		req.requires = q.Get("requires")
+	}
+
+	req.redact, err = requestRedact(q.Get("redact"))
+	if err != nil {
+		return req, err
+	}
+
+	req.raw = req.id == "" && q.Get("raw") == "true"
+	if req.raw && req.redact != "" {
+		return req, badRequestString("raw and redact cannot be used together")
+	}
+
+	req.limit, req.cursor, err = requestPage(q)
+	if err != nil {
+		return req, err
+	}
+
+	req.ifNone = requestETags(hreq.Header.Get("If-None-Match"))
+
+	req.since, req.hasSince, err = requestSince(q)
+	if err != nil {
+		return req, err
+	}
+
+	req.olderThan, err = requestOlderThan(req.method, req.id, q)
+	if err != nil {
+		return req, err
+	}
+
+	req.merge, err = requestMerge(req.method, req.id, q.Get("merge"))
+	if err != nil {
+		return req, err
+	}
+
+	req.strict, err = requestStrict(req.method, req.id, q)
+	if err != nil {
+		return req, err
+	}
+
+	req.matchBody, err = requestMatchBody(req.method, req.id, q)
+	if err != nil {
+		return req, err
+	}
+
+	req.previewResult, err = requestPreviewResult(req.method, q)
+	if err != nil {
+		return req, err
+	}
+
+	req.minimal = preferMinimal(hreq.Header)
+	req.gzip = acceptsGzip(hreq.Header)
+
+	req.ttl, err = requestTTL(req.method, req.id, hreq.Header.Get("X-Route-TTL"))
+	if err != nil {
+		return req, err
+	}
+
+	if sub != "" {
+		return f.preprocessSubresource(hreq, req, sub)
+	}
+
+	req.routes, err = requestCreateIfMissing(req.method, req.id, q.Get("createIfMissing"))
+	if err != nil {
+		return req, err
+	}
+
+	if req.createIfMissing = len(req.routes) > 0; req.createIfMissing {
+		problems := append(f.checkSelfPath(req), f.checkPredicates(req)...)
+		problems = append(problems, f.checkFilterArgs(req)...)
+		problems = append(problems, f.checkUnreachable(req)...)
+		if len(problems) > 0 {
+			return req, errInvalidRoutes{problems: problems}
+		}
+
+		if problems := f.checkContent(req); len(problems) > 0 {
+			return req, errInvalidRoutes{problems: problems, unprocessable: true}
+		}
+	}
+
+	if canUseContent(req.method, req.id) {
+		contentType, params, err := getContentType(req.method, req.id, hreq.Header.Get("Content-Type"))
+		if err != nil {
+			return req, err
+		}
+
+		b, err := ioutil.ReadAll(hreq.Body)
+		if err != nil {
+			return req, err
+		}
+
+		b, err = decodeTransferEncoding(hreq.Header.Get("Content-Transfer-Encoding"), b)
+		if err != nil {
+			return req, err
+		}
+
+		if !utf8.Valid(b) {
+			return req, badRequestString("invalid UTF-8 content")
+		}
+
+		skipperJSON := req.accept == responseFormatSkipperJSON
+		tarContent := req.tar || contentType == contentTypeTar
+		if !skipperJSON && !tarContent && isPatchDocument(req.method, contentType, b) {
+			req.deltas, err = parsePatch(req.id, b)
+			req.checkDeltas = f.deltaChecks(req)
+			if problems := f.checkFilterArgs(req); err == nil && len(problems) > 0 {
+				err = errInvalidRoutes{problems: problems}
+			}
+
+			if verr, ok := err.(errInvalidRoutes); ok {
+				format, _ := decideContentType(req.accept)
+				verr.json = format == responseFormatJSON
+				err = verr
+			}
+
+			return req, err
+		}
+
+		var (
+			r []*eskip.Route
+			i []string
+		)
+
+		switch {
+		case skipperJSON:
+			r, err = parseSkipperJSON(b)
+		case tarContent:
+			r, err = parseTar(b, req.merge, f.lenient)
+		case req.merge != "":
+			r, err = mergeMultipart(contentType, params["boundary"], b, req.merge, f.lenient)
+		default:
+			r, i, err = parseContent(req.method, req.id, contentType, params, b, f.lenient, f.idSeparator)
+		}
+
+		if err != nil {
+			return req, err
+		}
+
+		req.body, req.bodyType = b, hreq.Header.Get("Content-Type")
+
+		if req.id != "" && len(r) > 1 {
+			return req, badRequestString("no multiple routes allowed")
+		}
+
+		req.routes = r
+		req.ids = i
+		if err := f.validateRoutes(req); err != nil {
+			return req, err
+		}
+	}
+
+	return req, nil
+}
+
+// validateRoutes checks the routes sent in the content of a request, or, for
+// the polled documents, fetched from the poll source.
+func (f *filter) validateRoutes(req request) error {
+	var problems []string
+	if req.id == "" {
+		problems = checkBatch(req.method, req.routes)
+	}
+
+	problems = append(problems, checkReservedIDs(req)...)
+	problems = append(problems, f.checkSelfPath(req)...)
+	if req.method != "DELETE" {
+		problems = append(problems, f.checkPredicates(req)...)
+		problems = append(problems, f.checkFilterArgs(req)...)
+		problems = append(problems, f.checkUnreachable(req)...)
+	}
+
+	format, _ := decideContentType(req.accept)
+	if len(problems) > 0 {
+		return errInvalidRoutes{problems: problems, json: format == responseFormatJSON}
+	}
+
+	if req.method != "DELETE" {
+		if problems := f.checkContent(req); len(problems) > 0 {
+			return errInvalidRoutes{
+				problems:      problems,
+				json:          format == responseFormatJSON,
+				unprocessable: true,
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *filter) serveError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", contentTypeText)
+
+	if berr, ok := err.(errBadRequest); ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(berr.Error()))
+		return
+	}
+
+	if verr, ok := err.(errInvalidRoutes); ok {
+		status := http.StatusBadRequest
+		if verr.unprocessable {
+			status = http.StatusUnprocessableEntity
+		}
+
+		if verr.json {
+			if b, err := json.Marshal(verr.problems); err == nil {
+				w.Header().Set("Content-Type", contentTypeJSON)
+				w.WriteHeader(status)
+				w.Write(b)
+				return
+			}
+		}
+
+		w.WriteHeader(status)
+		w.Write([]byte(strings.Join(verr.problems, "\n")))
+		return
+	}
+
+	if cerr, ok := err.(errConflictingRoutes); ok {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(cerr.Error()))
+		return
+	}
+
+	if merr, ok := err.(errMissingRoutes); ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(merr.Error()))
+		return
+	}
+
+	if rerr, ok := err.(errTooManyRequests); ok {
+		seconds := int((rerr.retryAfter + time.Second - 1) / time.Second)
+		if seconds < 1 {
+			seconds = 1
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	if ferr, ok := err.(errForbidden); ok {
+		status := http.StatusForbidden
+		if serr, ok := ferr.err.(statusError); ok {
+			status = serr.StatusCode()
+		}
+
+		w.WriteHeader(status)
+		w.Write([]byte(ferr.Error()))
+		return
+	}
+
+	switch err {
+	case errMethodNotSupported:
+		if w.Header().Get("Allow") == "" {
+			w.Header().Set("Allow", allowedMethods)
+		}
+
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	case errNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case errUnsupportedMediaType:
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+	case errGone:
+		w.WriteHeader(http.StatusGone)
+	case errConflict:
+		w.WriteHeader(http.StatusConflict)
+	case errUnauthorized:
+		w.WriteHeader(http.StatusUnauthorized)
+	case errPreconditionFailed:
+		w.WriteHeader(http.StatusPreconditionFailed)
+	case errNotReady, errOverloaded:
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		f.log.Error("server error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func decideContentType(f responseFormat) (responseFormat, string) {
+	switch {
+	case f&responseFormatZip != 0:
+		return responseFormatZip, "application/zip"
+	case f&responseFormatSkipperJSON != 0:
+		return responseFormatSkipperJSON, contentTypeJSON
+	case f&responseFormatJSON != 0:
+		return responseFormatJSON, contentTypeJSON
+	case f&responseFormatEskip != 0:
+		return responseFormatEskip, contentTypeEskip
+	case f&responseFormatHTML != 0:
+		return responseFormatHTML, contentTypeHTML
+	default:
+		return responseFormatText, contentTypeText
+	}
+}
+
+func writeEskip(w io.Writer, req request, rsp response) error {
+	if req.id == "" && req.sections != "" {
+		return writeSections(w, req, rsp)
+	}
+
+	if req.id == "" && (rsp.expanded != nil || hasDisabled(rsp.meta)) {
+		return writeExpanded(w, req, rsp)
+	}
+
+	var s string
+	if req.id == "" {
+		s = eskip.Print(req.pretty, rsp.routes...)
+	} else {
+		s = rsp.routes[0].Print(req.pretty)
+		if rsp.meta[rsp.routes[0].Id].disabled {
+			s = disabledComment + s
+		}
+	}
+
+	_, err := w.Write([]byte(s))
+	return err
+}
+
+func writeZip(w io.Writer, req request, rsp response) error {
+	z := zip.NewWriter(w)
+	for _, r := range rsp.routes {
+		f, err := z.Create(r.Id + ".eskip")
+		if err != nil {
+			return err
+		}
+
+		if _, err := f.Write([]byte(eskip.Print(req.pretty, r))); err != nil {
+			return err
+		}
+	}
+
+	return z.Close()
+}
+
+func writeResponse(w http.ResponseWriter, req request, rsp response) error {
+	if rsp.nextCursor != "" {
+		w.Header().Set("X-Config-Next-Cursor", rsp.nextCursor)
+	}
+
+	if len(rsp.deletedIDs) > 0 {
+		w.Header().Set("X-Config-Deleted-IDs", strings.Join(rsp.deletedIDs, ","))
+	}
+
+	if rsp.delta {
+		w.Header().Set("X-Config-Delta", "true")
+	}
+
+	if req.id == "" {
+		w.Header().Set("X-Config-Version", strconv.Itoa(rsp.version))
+	}
+
+	if rsp.raw != nil {
+		ct := rsp.rawType
+		if ct == "" {
+			ct = contentTypeText
+		}
+
+		w.Header().Set("Content-Type", ct)
+		if req.method == "HEAD" {
+			return nil
+		}
+
+		_, err := w.Write(rsp.raw)
+		return err
+	}
+
+	f, ct := decideContentType(req.accept)
+	w.Header().Set("Content-Type", ct)
+	write := writeEskip
+	switch f {
+	case responseFormatJSON:
+		write = writeJSON
+	case responseFormatZip:
+		w.Header().Set("Content-Disposition", `attachment; filename="routes.zip"`)
+		write = writeZip
+	case responseFormatHTML:
+		write = writeHTML
+	case responseFormatSkipperJSON:
+		write = writeSkipperJSON
+	}
+
+	// the compression is applied independent from the negotiated content type,
+	// the content type header always tells the format of the decompressed body
+	w.Header().Add("Vary", "Accept-Encoding")
+	if req.gzip {
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+
+	// the trailer tells the clients whether the body was written completely,
+	// or it was truncated by an error
+	if req.method != "HEAD" {
+		w.Header().Set("Trailer", "X-Config-Complete")
+	}
+
+	if rsp.created {
+		w.WriteHeader(http.StatusCreated)
+	}
+
+	if req.method == "HEAD" {
+		return nil
+	}
+
+	err := writeBody(w, write, req, rsp)
+	w.Header().Set("X-Config-Complete", strconv.FormatBool(err == nil))
+	return err
+}
+
+func writeBody(
+	w io.Writer,
+	write func(io.Writer, request, response) error,
+	req request,
+	rsp response,
+) error {
+	if !req.gzip {
+		return write(w, req, rsp)
+	}
+
+	gz := gzip.NewWriter(w)
+	if err := write(gz, req, rsp); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+func (f *filter) serveDebug(w http.ResponseWriter, req request) {
+	if req.method != "GET" && req.method != "HEAD" {
+		w.Header().Set("Allow", "HEAD, GET")
+		f.serveError(w, errMethodNotSupported)
+		return
+	}
+
+	c := make(chan debugState)
+	f.debug <- c
+	b, err := json.Marshal(<-c)
+	if err != nil {
+		f.serveError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if req.method == "GET" {
+		w.Write(b)
+	}
+}
+
+// serveApplied tells whether the routing has received the routing table up to
+// a given version.
+func (f *filter) serveApplied(w http.ResponseWriter, hreq *http.Request, req request) {
+	if req.method != "GET" && req.method != "HEAD" {
+		w.Header().Set("Allow", "HEAD, GET")
+		f.serveError(w, errMethodNotSupported)
+		return
+	}
+
+	version, err := strconv.Atoi(hreq.URL.Query().Get("version"))
+	if err != nil || version < 0 {
+		f.serveError(w, badRequestString("invalid version"))
+		return
+	}
+
+	c := make(chan appliedState)
+	f.applied <- c
+	a := <-c
+	if version > a.version {
+		f.serveError(w, badRequestString("unknown version"))
+		return
+	}
+
+	b, err := json.Marshal(struct {
+		Applied        bool `json:"applied"`
+		AppliedVersion int  `json:"appliedVersion"`
+	}{
+		Applied:        a.delivered >= version,
+		AppliedVersion: a.delivered,
+	})
+	if err != nil {
+		f.serveError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if req.method == "GET" {
+		w.Write(b)
+	}
+}
+
+// serveStatus tells whether the data client is degraded, because the last update
+// delivered to the routing carried an error. In the degraded state, it responds
+// with 503.
+func (f *filter) serveStatus(w http.ResponseWriter, req request) {
+	if req.method != "GET" && req.method != "HEAD" {
+		w.Header().Set("Allow", "HEAD, GET")
+		f.serveError(w, errMethodNotSupported)
+		return
+	}
+
+	c := make(chan statusState)
+	f.status <- c
+	st := <-c
+	b, err := json.Marshal(st)
+	if err != nil {
+		f.serveError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if st.Degraded {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if req.method == "GET" {
+		w.Write(b)
+	}
+}
+
+// namespaced returns a copy of the filter, that prefixes the IDs with the
+// namespace.
+func (f *filter) namespaced(namespace string) *filter {
+	if namespace == "" {
+		return f
+	}
+
+	c := *f
+	c.idPrefix += namespace + namespaceSeparator
+	return &c
+}
+
+// roundTrip passes the request to the data client, and returns its response.
+// The individual routes are read without the data client.
+func (f *filter) roundTrip(req request) response {
+	f.operations.inc(req.method)
+	nf := f.namespaced(req.namespace)
+	sreq := nf.prefixRequest(f.lowercaseRequest(req))
+	if req.namespace != "" || f.scoped {
+		sreq.namespace = nf.idPrefix
+	}
+
+	if req.id != "" && (req.method == "GET" || req.method == "HEAD") && !req.createIfMissing && !req.trash && !req.diff {
+		return nf.unprefixResponse(f.reads.get(sreq, f.clock.Now()))
+	}
+
+	rspChan := make(chan response)
+	sreq.response = rspChan
+	f.request <- sreq
+	return nf.unprefixResponse(<-rspChan)
+}
+
+// serveIDs lists the IDs of all the routes, as a JSON array, or as plain text,
+// one ID per line.
+func (f *filter) serveIDs(w http.ResponseWriter, req request) {
+	if req.method != "GET" && req.method != "HEAD" {
+		w.Header().Set("Allow", "HEAD, GET")
+		f.serveError(w, errMethodNotSupported)
+		return
+	}
+
+	req.id = ""
+	rsp := f.roundTrip(req)
+	if rsp.err != nil {
+		f.serveError(w, rsp.err)
+		return
+	}
+
+	ids := routesToIDs(rsp.routes)
+	if ids == nil {
+		ids = []string{}
+	}
+
+	var b []byte
+	if format, ct := decideContentType(req.accept); format == responseFormatJSON {
+		w.Header().Set("Content-Type", ct)
+		b, _ = json.Marshal(ids)
+	} else {
+		w.Header().Set("Content-Type", contentTypeText)
+		if len(ids) > 0 {
+			b = []byte(strings.Join(ids, "\n") + "\n")
+		}
+	}
+
+	if req.method == "GET" {
+		w.Write(b)
+	}
+}
+
+func (f *filter) limitRate(hreq *http.Request) error {
+	switch hreq.Method {
+	case "GET", "HEAD":
+		if f.readLimit == nil {
+			return nil
+		}
+
+		if retryAfter := f.readLimit.allow(hreq, f.clock.Now()); retryAfter > 0 {
+			return errTooManyRequests{retryAfter}
+		}
+	}
+
+	return nil
+}
+
+func (f *filter) ServeHTTP(w http.ResponseWriter, hreq *http.Request) {
+	for name, values := range f.responseHeaders {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+
+	if f.inflight != nil {
+		select {
+		case f.inflight <- struct{}{}:
+			defer func() { <-f.inflight }()
+		default:
+			f.serveError(w, errOverloaded)
+			return
+		}
+	}
+
+	if err := f.limitRate(hreq); err != nil {
+		f.serveError(w, err)
+		return
+	}
+
+	if len(f.signingKey) > 0 && mutatingMethod(hreq.Method) {
+		if err := verifySignature(f.signingKey, hreq); err != nil {
+			f.serveError(w, err)
+			return
+		}
+	}
+
+	req, err := f.preprocessRequest(hreq)
+	if err != nil {
+		f.serveError(w, err)
+		return
+	}
+
+	if f.readOnly && isWrite(req) {
+		w.Header().Set("Allow", "OPTIONS, HEAD, GET")
+		f.serveError(w, errMethodNotSupported)
+		return
+	}
+
+	// the body of the changing requests was verified before parsing
+	if len(f.signingKey) > 0 && isWrite(req) && !mutatingMethod(hreq.Method) {
+		if err := verifyCreateIfMissing(f.signingKey, hreq); err != nil {
+			f.serveError(w, err)
+			return
+		}
+	}
+
+	if f.authorize != nil {
+		err := f.authorize(hreq, req.method, req.id)
+		if err == nil && req.createIfMissing {
+			err = f.authorize(hreq, "PUT", req.id)
+		}
+
+		if err != nil {
+			f.serveError(w, forbidden(err))
+			return
+		}
+	}
+
+	if f.authorizeRoutes != nil && isWrite(req) {
+		if err := f.checkRoutesAuthorized(req); err != nil {
+			f.serveError(w, forbidden(err))
+			return
+		}
+	}
+
+	if f.debug != nil && req.id == "debug" {
+		f.serveDebug(w, req)
+		return
+	}
+
+	if req.id == "applied" {
+		f.serveApplied(w, hreq, req)
+		return
+	}
+
+	if req.id == "status" {
+		f.serveStatus(w, req)
+		return
+	}
+
+	if req.id == "metrics" {
+		f.serveMetrics(w, req)
+		return
+	}
+
+	if f.trash && req.id == trashID && !req.trash {
+		if req.method != "GET" && req.method != "HEAD" {
+			w.Header().Set("Allow", "HEAD, GET")
+			f.serveError(w, errMethodNotSupported)
+			return
+		}
+
+		// the trash is listed like the root
+		req.id, req.trash = "", true
+	}
+
+	switch req.method {
+	case "OPTIONS":
+		w.Header().Set("Allow", allowedMethods)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(APIDescription))
+		return
+	}
+
+	if req.id == "ids" {
+		f.serveIDs(w, req)
+		return
+	}
+
+	if req.id == matchID {
+		f.serveMatch(w, hreq, req)
+		return
+	}
+
+	if req.id == schemaID {
+		f.serveSchema(w, req)
+		return
+	}
+
+	rsp := f.roundTrip(req)
+	if req.redact != "" {
+		rsp.routes = redactRoutes(rsp.routes, req.redact)
+		rsp.expanded = mapLoopbackTargets(
+			rsp.expanded,
+			func(id string) string { return id },
+			func(r []*eskip.Route) []*eskip.Route { return redactRoutes(r, req.redact) },
+		)
+	}
+
+	if req.withTraffic {
+		rsp.traffic = f.routesTraffic(req, rsp)
+	}
+
+	if len(rsp.ignoredDefaults) > 0 {
+		w.Header().Set("X-Ignored-Defaults", strings.Join(rsp.ignoredDefaults, ","))
+	}
+
+	if rsp.err != nil {
+		f.serveError(w, rsp.err)
+		return
+	}
+
+	if rsp.etag != "" {
+		w.Header().Set("ETag", rsp.etag)
+	}
+
+	if mutatingMethod(req.method) {
+		w.Header().Set("X-Config-Version", strconv.Itoa(rsp.version))
+	}
+
+	if rsp.sequence > 0 {
+		w.Header().Set("X-Config-Sequence", strconv.Itoa(rsp.sequence))
+	}
+
+	if rsp.notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if rsp.diff != nil {
+		f.serveDiff(w, req, rsp)
+		return
+	}
+
+	if req.txn == txnBegin {
+		w.Header().Set("X-Config-Transaction", rsp.txnToken)
+		writeTransactionToken(w, rsp.txnToken)
+		return
+	}
+
+	if req.analyze == analyzeShadowing {
+		f.serveShadowing(w, req, rsp)
+		return
+	}
+
+	if rsp.withContent {
+		if req.previewResult {
+			// the preview contains the complete table, also for the
+			// individual routes
+			req.id = ""
+		}
+
+		writeResponse(w, req, rsp)
+		return
+	}
+
+	if req.id == "" && req.deltas != nil {
+		status := http.StatusOK
+		for _, p := range rsp.patched {
+			if p.Status != http.StatusOK {
+				status = http.StatusMultiStatus
+				break
+			}
+		}
+
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(status)
+		writePatchStatus(w, rsp.patched)
+		return
+	}
+
+	if !mutatingMethod(req.method) {
+		return
+	}
+
+	if f.noContent || req.minimal {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if format, ct := decideContentType(req.accept); format == responseFormatJSON {
+		w.Header().Set("Content-Type", ct)
+		json.NewEncoder(w).Encode(rsp.summary)
 	}
 }
 
 func (f *filter) Request(ctx filters.FilterContext) {
-	id := ctx.PathParam("routeid")
-	println(id)
+	// the routing may ignore the trailing slash, but it is not part of the id
+	id := trimTrailingSlash(ctx.PathParam("routeid"))
 	ctx.Request().Header.Set("X-Config-RouteID", id)
+	ctx.Request().Header.Set("X-Config-Subpath", strings.Trim(ctx.PathParam("subpath"), "/"))
 	serve.ServeHTTP(ctx, f)
 }
 
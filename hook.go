@@ -0,0 +1,127 @@
+package configfilter
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryBackoff  = 100 * time.Millisecond
+)
+
+// jitter returns a random duration between the half and the whole of d.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// callHook calls a change hook, retrying with exponential, jittered backoff on
+// failures.
+func (s *Spec) callHook(name string, hook func([]*eskip.Route, []string) error, routes []*eskip.Route, deletedIDs []string) {
+	backoff := s.retryBackoff
+	for i := 0; ; i++ {
+		err := hook(routes, deletedIDs)
+		if err == nil {
+			return
+		}
+
+		if i >= s.retryAttempts {
+			s.log.Errorf("%s failed after %d retries: %v", name, i, err)
+			return
+		}
+
+		s.log.Warnf("%s failed, retrying: %v", name, err)
+		select {
+		case <-s.clock.After(jitter(backoff)):
+		case <-s.stop:
+			return
+		}
+
+		backoff *= 2
+	}
+}
+
+// hookChange is a change queued for the change hook, and, as the routing
+// received it, for the mirror.
+type hookChange struct {
+	update   updateMessage
+	mirrored updateMessage
+}
+
+// hookQueue holds the changes waiting for the change hook and the mirror. A
+// single worker takes them in the order of the changes.
+type hookQueue struct {
+	mx      sync.Mutex
+	changes []hookChange
+	signal  chan struct{}
+}
+
+// notifyChange queues the change for the change hook and the mirror, without
+// blocking the handling of the requests.
+func (s *Spec) notifyChange(update updateMessage) {
+	if s.hooks == nil || len(update.routes) == 0 && len(update.deletedIDs) == 0 {
+		return
+	}
+
+	c := hookChange{update: update}
+	if s.mirror != nil {
+		c.mirrored = s.mirroredUpdate(update)
+	}
+
+	s.hooks.mx.Lock()
+	s.hooks.changes = append(s.hooks.changes, c)
+	s.hooks.mx.Unlock()
+
+	select {
+	case s.hooks.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (q *hookQueue) next() (hookChange, bool) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	if len(q.changes) == 0 {
+		return hookChange{}, false
+	}
+
+	c := q.changes[0]
+	q.changes = q.changes[1:]
+	return c, true
+}
+
+// callHooks calls the change hook and the mirror with the queued changes,
+// until the data client is closed. The changes still queued then are dropped.
+func (s *Spec) callHooks() {
+	for {
+		select {
+		case <-s.hooks.signal:
+		case <-s.stop:
+			return
+		}
+
+		for {
+			c, ok := s.hooks.next()
+			if !ok {
+				break
+			}
+
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+
+			if s.onChange != nil {
+				s.callHook("change hook", s.onChange, c.update.routes, c.update.deletedIDs)
+			}
+
+			if s.mirror != nil && c.mirrored.hasData() {
+				s.callHook("mirror", s.mirror, c.mirrored.routes, c.mirrored.deletedIDs)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package configfilter
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type htmlRoute struct {
+	ID, Predicates, Backend, Filters string
+}
+
+// the template escapes the route content
+var htmlTable = template.Must(template.New("routes").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Routes</title></head>
+<body>
+<table>
+<tr><th>ID</th><th>Predicates</th><th>Backend</th><th>Filters</th></tr>
+{{range .}}<tr><td>{{.ID}}</td><td>{{.Predicates}}</td><td>{{.Backend}}</td><td>{{.Filters}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func expressionString(e jsonExpression) string {
+	var args []string
+	for _, a := range e.Args {
+		if s, ok := a.(string); ok {
+			args = append(args, strconv.Quote(s))
+		} else {
+			args = append(args, fmt.Sprint(a))
+		}
+	}
+
+	return e.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func expressionsString(e []jsonExpression, separator string) string {
+	var s []string
+	for _, ei := range e {
+		s = append(s, expressionString(ei))
+	}
+
+	return strings.Join(s, separator)
+}
+
+func writeHTML(w io.Writer, req request, rsp response) error {
+	var routes []htmlRoute
+	for _, r := range rsp.routes {
+		routes = append(routes, htmlRoute{
+			ID:         r.Id,
+			Predicates: expressionsString(jsonPredicates(r), " && "),
+			Backend:    jsonBackend(r),
+			Filters:    expressionsString(jsonFilters(r), " -> "),
+		})
+	}
+
+	return htmlTable.Execute(w, routes)
+}
@@ -0,0 +1,81 @@
+package configfilter
+
+import (
+	"crypto/sha256"
+	"time"
+)
+
+const defaultIdempotencyTTL = 10 * time.Minute
+
+type idempotentResult struct {
+	fingerprint [sha256.Size]byte
+	rsp         response
+	expires     time.Time
+}
+
+// idempotencyKey returns the key of the stored results, scoped to the
+// namespace of the request, so that the retries in different namespaces don't
+// share the results.
+func idempotencyKey(req request) string {
+	return req.namespace + "\n" + req.idempotencyKey
+}
+
+// changeFingerprint identifies the change sent with an idempotency key, to tell
+// the retries from the different changes reusing the same key.
+func changeFingerprint(req request) [sha256.Size]byte {
+	h := sha256.New()
+	for _, s := range []string{req.method, req.id, req.rename, req.restore, req.principal} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+
+	h.Write(req.body)
+	var f [sha256.Size]byte
+	copy(f[:], h.Sum(nil))
+	return f
+}
+
+// cachedResult returns the result of an earlier change with the same
+// idempotency key, when it is not expired. When the key was used for a
+// different change, it returns an error.
+func (s *Spec) cachedResult(req request) (response, bool) {
+	if req.idempotencyKey == "" || !mutatingMethod(req.method) {
+		return response{}, false
+	}
+
+	r, ok := s.idempotent[idempotencyKey(req)]
+	if !ok || !s.clock.Now().Before(r.expires) {
+		return response{}, false
+	}
+
+	if r.fingerprint != changeFingerprint(req) {
+		return response{err: errInvalidRoutes{
+			problems:      []string{"idempotency key reused for a different change"},
+			unprocessable: true,
+		}}, true
+	}
+
+	return r.rsp, true
+}
+
+// cacheResult stores the result of a successful change for the retries with the
+// same idempotency key. The failed changes are not stored, because they can be
+// retried safely.
+func (s *Spec) cacheResult(req request, rsp response) {
+	if req.idempotencyKey == "" || !mutatingMethod(req.method) || rsp.err != nil {
+		return
+	}
+
+	now := s.clock.Now()
+	for key, r := range s.idempotent {
+		if !now.Before(r.expires) {
+			delete(s.idempotent, key)
+		}
+	}
+
+	s.idempotent[idempotencyKey(req)] = idempotentResult{
+		fingerprint: changeFingerprint(req),
+		rsp:         rsp,
+		expires:     now.Add(s.idempotencyTTL),
+	}
+}
@@ -0,0 +1,406 @@
+package configfilter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+type jsonExpression struct {
+	Name string        `json:"name"`
+	Args []interface{} `json:"args"`
+}
+
+type jsonRoute struct {
+	ID         string           `json:"id"`
+	Predicates []jsonExpression `json:"predicates"`
+	Filters    []jsonExpression `json:"filters"`
+	Backend    string           `json:"backend"`
+	CreatedAt  time.Time        `json:"createdAt"`
+	UpdatedAt  time.Time        `json:"updatedAt"`
+	Disabled   bool             `json:"disabled,omitempty"`
+
+	// LastModifiedBy is read-only, it is ignored in the requests
+	LastModifiedBy string `json:"lastModifiedBy,omitempty"`
+
+	// Traffic is read-only, it is ignored in the requests
+	Traffic *jsonTraffic `json:"traffic,omitempty"`
+
+	// Weight is read-only, it is ignored in the requests
+	Weight int `json:"weight"`
+}
+
+// JSONOptions control the format of the routes returned in JSON.
+type JSONOptions struct {
+
+	// SnakeCase makes the field names snake_case, e.g. created_at, instead of
+	// camelCase, e.g. createdAt.
+	SnakeCase bool
+
+	// OmitEmpty omits the fields with empty values, e.g. the empty list of
+	// predicates or filters. By default, only the empty disabled and
+	// lastModifiedBy fields are omitted.
+	OmitEmpty bool
+}
+
+type jsonField struct {
+	camelCase, snakeCase string
+	value                interface{}
+	empty                bool
+	omitEmpty            bool
+}
+
+func (o JSONOptions) fields(jr jsonRoute) []jsonField {
+	return []jsonField{
+		{"id", "id", jr.ID, jr.ID == "", false},
+		{"predicates", "predicates", jr.Predicates, len(jr.Predicates) == 0, false},
+		{"filters", "filters", jr.Filters, len(jr.Filters) == 0, false},
+		{"backend", "backend", jr.Backend, jr.Backend == "", false},
+		{"createdAt", "created_at", jr.CreatedAt, jr.CreatedAt.IsZero(), false},
+		{"updatedAt", "updated_at", jr.UpdatedAt, jr.UpdatedAt.IsZero(), false},
+		{"disabled", "disabled", jr.Disabled, !jr.Disabled, true},
+		{"lastModifiedBy", "last_modified_by", jr.LastModifiedBy, jr.LastModifiedBy == "", true},
+		{"traffic", "traffic", jr.Traffic, jr.Traffic == nil, true},
+		{"weight", "weight", jr.Weight, jr.Weight == 0, false},
+	}
+}
+
+// marshal encodes the route with the field names and the omitted fields set
+// in the options, keeping the order of the fields.
+func (o JSONOptions) marshal(jr jsonRoute) (json.RawMessage, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	var written bool
+	for _, f := range o.fields(jr) {
+		if f.empty && (f.omitEmpty || o.OmitEmpty) {
+			continue
+		}
+
+		name := f.camelCase
+		if o.SnakeCase {
+			name = f.snakeCase
+		}
+
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+
+		if written {
+			b.WriteByte(',')
+		}
+
+		fmt.Fprintf(&b, "%q:", name)
+		b.Write(v)
+		written = true
+	}
+
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func sortedHeaders(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedHeaderRegexps(m map[string][]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func jsonPredicates(r *eskip.Route) []jsonExpression {
+	p := []jsonExpression{}
+	if r.Path != "" {
+		p = append(p, jsonExpression{"Path", []interface{}{r.Path}})
+	}
+
+	for _, h := range r.HostRegexps {
+		p = append(p, jsonExpression{"Host", []interface{}{h}})
+	}
+
+	for _, pr := range r.PathRegexps {
+		p = append(p, jsonExpression{"PathRegexp", []interface{}{pr}})
+	}
+
+	if r.Method != "" {
+		p = append(p, jsonExpression{"Method", []interface{}{r.Method}})
+	}
+
+	for _, k := range sortedHeaders(r.Headers) {
+		p = append(p, jsonExpression{"Header", []interface{}{k, r.Headers[k]}})
+	}
+
+	for _, k := range sortedHeaderRegexps(r.HeaderRegexps) {
+		for _, v := range r.HeaderRegexps[k] {
+			p = append(p, jsonExpression{"HeaderRegexp", []interface{}{k, v}})
+		}
+	}
+
+	for _, pi := range r.Predicates {
+		p = append(p, jsonExpression{pi.Name, pi.Args})
+	}
+
+	return p
+}
+
+func jsonFilters(r *eskip.Route) []jsonExpression {
+	f := []jsonExpression{}
+	for _, fi := range r.Filters {
+		f = append(f, jsonExpression{fi.Name, fi.Args})
+	}
+
+	return f
+}
+
+func jsonBackend(r *eskip.Route) string {
+	switch backendType(r) {
+	case backendShunt:
+		return "<shunt>"
+	case backendLoopback:
+		return "<loopback>"
+	default:
+		return r.Backend
+	}
+}
+
+func toJSON(r *eskip.Route, m routeMeta) jsonRoute {
+	return jsonRoute{
+		ID:             r.Id,
+		Predicates:     jsonPredicates(r),
+		Filters:        jsonFilters(r),
+		Backend:        jsonBackend(r),
+		CreatedAt:      m.created,
+		UpdatedAt:      m.updated,
+		Disabled:       m.disabled,
+		LastModifiedBy: m.modifiedBy,
+		Weight:         routeWeight(r),
+	}
+}
+
+// toJSONTraffic converts the route with the metadata and the traffic
+// statistics in the response.
+func toJSONTraffic(r *eskip.Route, rsp response) jsonRoute {
+	jr := toJSON(r, rsp.meta[r.Id])
+	jr.Traffic = rsp.traffic[r.Id]
+	return jr
+}
+
+func writeJSON(w io.Writer, req request, rsp response) error {
+	var v interface{}
+	if req.jsonOptions != (JSONOptions{}) {
+		var routes []json.RawMessage
+		for _, r := range rsp.routes {
+			jr, err := req.jsonOptions.marshal(toJSONTraffic(r, rsp))
+			if err != nil {
+				return err
+			}
+
+			routes = append(routes, jr)
+		}
+
+		if req.id == "" {
+			v = append([]json.RawMessage{}, routes...)
+		} else {
+			v = routes[0]
+		}
+	} else if req.id == "" {
+		routes := []jsonRoute{}
+		for _, r := range rsp.routes {
+			routes = append(routes, toJSONTraffic(r, rsp))
+		}
+
+		v = routes
+	} else {
+		v = toJSONTraffic(rsp.routes[0], rsp)
+	}
+
+	enc := json.NewEncoder(w)
+	if req.pretty {
+		enc.SetIndent("", "  ")
+	}
+
+	return enc.Encode(v)
+}
+
+// writeSkipperJSON writes the routes in the JSON representation of the Skipper
+// eskip routes, as encoded by the standard library.
+func writeSkipperJSON(w io.Writer, req request, rsp response) error {
+	var v interface{} = rsp.routes
+	if req.id == "" && len(rsp.routes) == 0 {
+		v = []*eskip.Route{}
+	} else if req.id != "" {
+		v = rsp.routes[0]
+	}
+
+	enc := json.NewEncoder(w)
+	if req.pretty {
+		enc.SetIndent("", "  ")
+	}
+
+	return enc.Encode(v)
+}
+
+// parseSkipperJSON parses either a single route object or an array of routes,
+// in the JSON representation of the Skipper eskip routes.
+func parseSkipperJSON(b []byte) ([]*eskip.Route, error) {
+	var routes []*eskip.Route
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '{' {
+		var r eskip.Route
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil, badRequest(err)
+		}
+
+		return []*eskip.Route{&r}, nil
+	}
+
+	if err := json.Unmarshal(b, &routes); err != nil {
+		return nil, badRequest(err)
+	}
+
+	return routes, nil
+}
+
+// isJSON tells whether a document without a content type looks like JSON.
+func isJSON(b []byte) bool {
+	b = bytes.TrimSpace(b)
+	return len(b) > 0 && (b[0] == '[' || b[0] == '{')
+}
+
+func stringArgs(p jsonExpression, n int) ([]string, error) {
+	if len(p.Args) != n {
+		return nil, fmt.Errorf("invalid number of arguments for %s", p.Name)
+	}
+
+	var s []string
+	for _, a := range p.Args {
+		as, ok := a.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid argument for %s", p.Name)
+		}
+
+		s = append(s, as)
+	}
+
+	return s, nil
+}
+
+func fromJSONPredicate(r *eskip.Route, p jsonExpression) error {
+	var n int
+	switch p.Name {
+	case "Path", "Host", "PathRegexp", "Method":
+		n = 1
+	case "Header", "HeaderRegexp":
+		n = 2
+	default:
+		r.Predicates = append(r.Predicates, &eskip.Predicate{Name: p.Name, Args: p.Args})
+		return nil
+	}
+
+	args, err := stringArgs(p, n)
+	if err != nil {
+		return err
+	}
+
+	switch p.Name {
+	case "Path":
+		r.Path = args[0]
+	case "Host":
+		r.HostRegexps = append(r.HostRegexps, args[0])
+	case "PathRegexp":
+		r.PathRegexps = append(r.PathRegexps, args[0])
+	case "Method":
+		r.Method = args[0]
+	case "Header":
+		if r.Headers == nil {
+			r.Headers = make(map[string]string)
+		}
+
+		r.Headers[args[0]] = args[1]
+	case "HeaderRegexp":
+		if r.HeaderRegexps == nil {
+			r.HeaderRegexps = make(map[string][]string)
+		}
+
+		r.HeaderRegexps[args[0]] = append(r.HeaderRegexps[args[0]], args[1])
+	}
+
+	return nil
+}
+
+func setJSONBackend(r *eskip.Route, backend string) {
+	r.Shunt = false
+	r.Backend = ""
+	switch backend {
+	case "<shunt>":
+		r.Shunt = true
+		r.BackendType = eskip.ShuntBackend
+	case "<loopback>":
+		r.BackendType = eskip.LoopBackend
+	default:
+		r.BackendType = eskip.NetworkBackend
+		r.Backend = backend
+	}
+}
+
+func fromJSON(jr jsonRoute) (*eskip.Route, error) {
+	r := &eskip.Route{Id: jr.ID}
+	for _, p := range jr.Predicates {
+		if err := fromJSONPredicate(r, p); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range jr.Filters {
+		r.Filters = append(r.Filters, &eskip.Filter{Name: f.Name, Args: f.Args})
+	}
+
+	if jr.Backend == "" {
+		return nil, fmt.Errorf("missing backend in route %s", jr.ID)
+	}
+
+	setJSONBackend(r, jr.Backend)
+	return r, nil
+}
+
+// parseJSON parses either a single route object or an array of routes.
+func parseJSON(b []byte) ([]*eskip.Route, error) {
+	var jr []jsonRoute
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '{' {
+		var single jsonRoute
+		if err := json.Unmarshal(b, &single); err != nil {
+			return nil, err
+		}
+
+		jr = []jsonRoute{single}
+	} else if err := json.Unmarshal(b, &jr); err != nil {
+		return nil, err
+	}
+
+	var routes []*eskip.Route
+	for _, jri := range jr {
+		r, err := fromJSON(jri)
+		if err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, r)
+	}
+
+	return routes, nil
+}
@@ -0,0 +1,80 @@
+package configfilter
+
+import (
+	"strings"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+// normalizeEskip drops the redundant separators from an eskip document: the
+// repeated or leading semicolons, i.e. the blank routes, and the commas
+// directly before a closing parenthesis. The string and regexp literals and
+// the comments are left unchanged.
+func normalizeEskip(s string) string {
+	var (
+		out  []rune
+		last = -1
+	)
+
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case c == '/' && i+1 < len(rs) && rs[i+1] == '/':
+			for ; i < len(rs) && rs[i] != '\n'; i++ {
+				out = append(out, rs[i])
+			}
+
+			if i < len(rs) {
+				out = append(out, rs[i])
+			}
+
+			continue
+		case c == '"' || c == '/' && last >= 0 && (out[last] == '(' || out[last] == ','):
+			out = append(out, c)
+			for i++; i < len(rs) && rs[i] != c; i++ {
+				if rs[i] == '\\' && i+1 < len(rs) {
+					out = append(out, rs[i])
+					i++
+				}
+
+				out = append(out, rs[i])
+			}
+
+			if i < len(rs) {
+				out = append(out, rs[i])
+			}
+		case c == ';' && (last < 0 || out[last] == ';'):
+			continue
+		case c == ')' && last >= 0 && out[last] == ',':
+			out = append(out[:last], out[last+1:]...)
+			out = append(out, c)
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			out = append(out, c)
+			continue
+		default:
+			out = append(out, c)
+		}
+
+		last = len(out) - 1
+	}
+
+	return string(out)
+}
+
+// parseEskip parses an eskip document. When lenient, and the parser rejects the
+// document, it retries with the normalized document.
+func parseEskip(s string, lenient bool) ([]*eskip.Route, error) {
+	r, err := eskip.Parse(s)
+	if err == nil || !lenient {
+		return r, err
+	}
+
+	if n := normalizeEskip(s); n != s {
+		if r, nerr := eskip.Parse(strings.TrimSpace(n)); nerr == nil {
+			return r, nil
+		}
+	}
+
+	return nil, err
+}
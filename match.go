@@ -0,0 +1,134 @@
+package configfilter
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+const matchID = "match"
+
+// matchRequest contains the attributes of a synthetic request, that the
+// routes are evaluated against.
+type matchRequest struct {
+	path, method, host string
+}
+
+func matchesPathSubtree(r *eskip.Route, path string) bool {
+	for _, p := range r.Predicates {
+		if p.Name != "PathSubtree" {
+			continue
+		}
+
+		if len(p.Args) != 1 {
+			return false
+		}
+
+		prefix, ok := p.Args[0].(string)
+		if !ok {
+			return false
+		}
+
+		prefix = strings.TrimSuffix(prefix, "/")
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesRegexps(rx []string, s string) bool {
+	for _, rxi := range rx {
+		re, err := regexp.Compile(rxi)
+		if err != nil || !re.MatchString(s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesMethod(r *eskip.Route, method string) bool {
+	for _, s := range methodSets(r) {
+		if !s[method] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// routeMatches tells whether the route would match the synthetic request.
+// Only the path, host and method predicates are evaluated. The routes with
+// header predicates don't match, because the request has no headers, and the
+// routes with other predicates don't match either, because they cannot be
+// evaluated.
+func routeMatches(r *eskip.Route, m matchRequest) bool {
+	if len(r.Headers) > 0 || len(r.HeaderRegexps) > 0 {
+		return false
+	}
+
+	for _, p := range r.Predicates {
+		if p.Name != "Methods" && p.Name != "PathSubtree" {
+			return false
+		}
+	}
+
+	if r.Path != "" && !pathsOverlap(r.Path, m.path) {
+		return false
+	}
+
+	return matchesPathSubtree(r, m.path) &&
+		matchesRegexps(r.PathRegexps, m.path) &&
+		matchesRegexps(r.HostRegexps, m.host) &&
+		matchesMethod(r, m.method)
+}
+
+// serveMatch responds with the routes that would match the request described
+// by the query parameters path, method and host, in the order that the routing
+// would try them. The disabled routes are not returned.
+func (f *filter) serveMatch(w http.ResponseWriter, hreq *http.Request, req request) {
+	if req.method != "GET" && req.method != "HEAD" {
+		w.Header().Set("Allow", "HEAD, GET")
+		f.serveError(w, errMethodNotSupported)
+		return
+	}
+
+	q := hreq.URL.Query()
+	m := matchRequest{
+		path:   q.Get("path"),
+		method: strings.ToUpper(q.Get("method")),
+		host:   q.Get("host"),
+	}
+
+	if !strings.HasPrefix(m.path, "/") {
+		f.serveError(w, badRequestString("missing or invalid path"))
+		return
+	}
+
+	if m.method == "" {
+		m.method = "GET"
+	}
+
+	req.id = ""
+	req.routeMethod = ""
+	rsp := f.roundTrip(req)
+	if rsp.err != nil {
+		f.serveError(w, rsp.err)
+		return
+	}
+
+	// the disabled routes are not served by the routing
+	var matching []*eskip.Route
+	for _, r := range rsp.routes {
+		if !rsp.meta[r.Id].disabled && routeMatches(r, m) {
+			matching = append(matching, r)
+		}
+	}
+
+	rsp.routes = effectiveOrder(matching)
+	writeResponse(w, req, rsp)
+}
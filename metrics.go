@@ -0,0 +1,59 @@
+package configfilter
+
+import "time"
+
+const (
+	metricsUpdateLag     = "configfilter.update.lag"
+	metricsUpdatePending = "configfilter.update.pending"
+
+	defaultMetricsInterval = time.Second
+)
+
+// Metrics receives the measurements of the data client. Skipper's metrics
+// implementation can be used.
+type Metrics interface {
+	MeasureSince(key string, start time.Time)
+	UpdateGauge(key string, value float64)
+}
+
+// measureQueued starts measuring the pending time with the oldest change not
+// yet consumed by the routing.
+func (s *Spec) measureQueued() {
+	if s.metrics == nil {
+		return
+	}
+
+	if s.pendingSince.IsZero() {
+		s.pendingSince = s.clock.Now()
+	}
+
+	s.measurePending()
+}
+
+// measurePending reports, in seconds, since when the oldest change not yet
+// consumed by the routing is waiting. While the change is waiting, it is
+// reported periodically, so that the gauge grows even when no other change is
+// queued.
+func (s *Spec) measurePending() {
+	if s.pendingSince.IsZero() {
+		return
+	}
+
+	s.metrics.UpdateGauge(metricsUpdatePending, s.clock.Now().Sub(s.pendingSince).Seconds())
+	if s.pendingTick == nil {
+		s.pendingTick = s.clock.After(s.gaugeInterval)
+	}
+}
+
+// measureDelivered reports the time between accepting the oldest change and
+// the routing consuming it.
+func (s *Spec) measureDelivered() {
+	if s.metrics == nil || s.pendingSince.IsZero() {
+		return
+	}
+
+	s.metrics.MeasureSince(metricsUpdateLag, s.pendingSince)
+	s.metrics.UpdateGauge(metricsUpdatePending, 0)
+	s.pendingSince = time.Time{}
+	s.pendingTick = nil
+}
@@ -0,0 +1,81 @@
+package configfilter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+const mirrorTimeout = 30 * time.Second
+
+func mirrorRequest(ctx context.Context, client *http.Client, method, u, body string) error {
+	req, err := http.NewRequest(method, u, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "text/plain")
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code from the mirror: %d", rsp.StatusCode)
+	}
+
+	return nil
+}
+
+// mirrorTo returns a change hook that forwards the changes to the config API of
+// a peer, the changed routes with PATCH and the IDs of the deleted ones with
+// DELETE. The requests time out, and they are cancelled when the data client
+// is closed.
+func (s *Spec) mirrorTo(u string) func([]*eskip.Route, []string) error {
+	client := &http.Client{Timeout: mirrorTimeout}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.stop
+		cancel()
+	}()
+
+	return func(routes []*eskip.Route, deletedIDs []string) error {
+		if len(routes) > 0 {
+			if err := mirrorRequest(ctx, client, "PATCH", u, eskip.Print(false, routes...)); err != nil {
+				return err
+			}
+		}
+
+		if len(deletedIDs) > 0 {
+			return mirrorRequest(ctx, client, "DELETE", u, strings.Join(deletedIDs, ","))
+		}
+
+		return nil
+	}
+}
+
+// mirroredUpdate returns the change forwarded to the peer: the change that the
+// routing receives, where the disabled routes are deleted, with the IDs as the
+// API of the peer accepts them, without the ID prefix.
+func (s *Spec) mirroredUpdate(update updateMessage) updateMessage {
+	served := s.servedUpdate(update)
+	var m updateMessage
+	for _, r := range served.routes {
+		c := *r
+		c.Id = strings.TrimPrefix(c.Id, s.idPrefix)
+		m.routes = append(m.routes, &c)
+	}
+
+	for _, id := range served.deletedIDs {
+		m.deletedIDs = append(m.deletedIDs, strings.TrimPrefix(id, s.idPrefix))
+	}
+
+	return m
+}
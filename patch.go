@@ -0,0 +1,273 @@
+package configfilter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+// routeDelta describes a partial change of an existing route, sent in a JSON
+// array to the root with PATCH.
+type routeDelta struct {
+	ID            string           `json:"id"`
+	Backend       *string          `json:"backend,omitempty"`
+	AddFilters    []jsonExpression `json:"addFilters,omitempty"`
+	RemoveFilters []string         `json:"removeFilters,omitempty"`
+	Disabled      *bool            `json:"disabled,omitempty"`
+
+	// Test, when set, makes the change apply only if the current route has
+	// the values in it
+	Test *routeFields `json:"test,omitempty"`
+}
+
+// routeFields contains the fields of a route that can be tested and set with
+// a conditional change.
+type routeFields struct {
+	Backend  *string `json:"backend"`
+	Disabled *bool   `json:"disabled"`
+}
+
+// routeDirective describes a change of an individual route, sent as a JSON
+// object with PATCH.
+type routeDirective struct {
+	RemoveFilter string       `json:"removeFilter"`
+	Disabled     *bool        `json:"disabled"`
+	Test         *routeFields `json:"test"`
+	Set          *routeFields `json:"set"`
+}
+
+type patchStatus struct {
+	ID     string `json:"id"`
+	Status int    `json:"status"`
+}
+
+// isPatchDocument tells whether the content of a PATCH request contains patch
+// directives instead of routes.
+func isPatchDocument(method, contentType string, b []byte) bool {
+	return method == "PATCH" &&
+		(contentType == "application/json" || contentType == "" && isJSON(b))
+}
+
+func parseDirective(id string, b []byte) ([]routeDelta, error) {
+	if b = bytes.TrimSpace(b); len(b) == 0 || b[0] != '{' {
+		return nil, badRequestString("a JSON object with the change of the route expected")
+	}
+
+	var d routeDirective
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, badRequest(err)
+	}
+
+	if d.Set != nil {
+		if d.Disabled != nil {
+			return nil, badRequestString("disabled and set cannot be used together")
+		}
+
+		d.Disabled = d.Set.Disabled
+	}
+
+	if d.RemoveFilter == "" && d.Disabled == nil && (d.Set == nil || d.Set.Backend == nil) {
+		return nil, badRequestString("missing change of the route")
+	}
+
+	delta := routeDelta{ID: id, Disabled: d.Disabled, Test: d.Test}
+	if d.Set != nil {
+		delta.Backend = d.Set.Backend
+	}
+
+	if d.RemoveFilter != "" {
+		delta.RemoveFilters = []string{d.RemoveFilter}
+	}
+
+	return []routeDelta{delta}, nil
+}
+
+func parsePatch(id string, b []byte) ([]routeDelta, error) {
+	if id != "" {
+		return parseDirective(id, b)
+	}
+
+	if b = bytes.TrimSpace(b); len(b) == 0 || b[0] != '[' {
+		return nil, badRequestString("a JSON array of route changes expected")
+	}
+
+	var d []routeDelta
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, badRequest(err)
+	}
+
+	var problems []string
+	for i, di := range d {
+		if di.ID == "" {
+			problems = append(problems, fmt.Sprintf("change without id at position %d", i+1))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, errInvalidRoutes{problems: problems}
+	}
+
+	return d, nil
+}
+
+// matchesTest tells whether the route has the values of the conditional change.
+func (s *Spec) matchesTest(r *eskip.Route, test *routeFields) bool {
+	if test == nil {
+		return true
+	}
+
+	if test.Backend != nil && jsonBackend(r) != *test.Backend {
+		return false
+	}
+
+	return test.Disabled == nil || *test.Disabled == s.disabled[r.Id]
+}
+
+// applyDelta returns a changed copy of the route.
+func applyDelta(r *eskip.Route, d routeDelta) *eskip.Route {
+	c := *r
+	if d.Backend != nil {
+		setJSONBackend(&c, *d.Backend)
+	}
+
+	if len(d.RemoveFilters) > 0 {
+		remove := make(map[string]bool)
+		for _, name := range d.RemoveFilters {
+			remove[name] = true
+		}
+
+		c.Filters = nil
+		for _, f := range r.Filters {
+			if !remove[f.Name] {
+				c.Filters = append(c.Filters, f)
+			}
+		}
+	}
+
+	if len(d.AddFilters) > 0 {
+		c.Filters = append([]*eskip.Filter(nil), c.Filters...)
+		for _, f := range d.AddFilters {
+			c.Filters = append(c.Filters, &eskip.Filter{Name: f.Name, Args: f.Args})
+		}
+	}
+
+	return &c
+}
+
+// patchDeltas applies the changes to the existing routes, and reports the
+// result for each ID. The changes of missing routes are reported with 404.
+func (s *Spec) patchDeltas(req request) (rsp response, update updateMessage) {
+	var changed []*eskip.Route
+	for _, d := range req.deltas {
+		if len(idsToRoutes([]string{d.ID}, s.defaults)) > 0 {
+			rsp.ignoredDefaults = append(rsp.ignoredDefaults, d.ID)
+			rsp.patched = append(rsp.patched, patchStatus{ID: d.ID, Status: http.StatusForbidden})
+			continue
+		}
+
+		current := idsToRoutes([]string{d.ID}, changed)
+		if len(current) == 0 {
+			current = idsToRoutes([]string{d.ID}, s.routes)
+		}
+
+		if len(current) == 0 {
+			rsp.patched = append(rsp.patched, patchStatus{ID: d.ID, Status: http.StatusNotFound})
+			continue
+		}
+
+		if !s.matchesTest(current[0], d.Test) {
+			rsp.patched = append(rsp.patched, patchStatus{ID: d.ID, Status: http.StatusPreconditionFailed})
+			continue
+		}
+
+		changed = append(removeRoutes(changed, current), applyDelta(current[0], d))
+		rsp.patched = append(rsp.patched, patchStatus{ID: d.ID, Status: http.StatusOK})
+	}
+
+	if req.checkDeltas != nil {
+		if err := req.checkDeltas(changed); err != nil {
+			return response{err: err}, updateMessage{}
+		}
+	}
+
+	s.routes, update.routes = upsertRoutes(s.routes, changed)
+	update.routes = s.applyDisabled(req.deltas, changed, update.routes)
+	return
+}
+
+// applyDisabled disables or enables the changed routes. The toggled routes are
+// included in the update, even when they were not changed otherwise.
+func (s *Spec) applyDisabled(deltas []routeDelta, changed, upserted []*eskip.Route) []*eskip.Route {
+	for _, d := range deltas {
+		if d.Disabled == nil || *d.Disabled == s.disabled[d.ID] {
+			continue
+		}
+
+		r := idsToRoutes([]string{d.ID}, changed)
+		if len(r) == 0 {
+			continue
+		}
+
+		if *d.Disabled {
+			s.disabled[d.ID] = true
+		} else {
+			delete(s.disabled, d.ID)
+		}
+
+		if len(idsToRoutes([]string{d.ID}, upserted)) == 0 {
+			upserted = append(upserted, r...)
+		}
+	}
+
+	return upserted
+}
+
+// copyDisabled copies the set of the disabled routes, to restore it when a
+// change is not kept.
+func copyDisabled(d map[string]bool) map[string]bool {
+	c := make(map[string]bool)
+	for id, di := range d {
+		c[id] = di
+	}
+
+	return c
+}
+
+// servedRoutes returns the routes that are not disabled.
+func (s *Spec) servedRoutes(routes []*eskip.Route) []*eskip.Route {
+	var served []*eskip.Route
+	for _, r := range routes {
+		if !s.disabled[r.Id] {
+			served = append(served, r)
+		}
+	}
+
+	return served
+}
+
+// servedUpdate converts the changes of the disabled routes into deletions for
+// the routing.
+func (s *Spec) servedUpdate(update updateMessage) updateMessage {
+	served := updateMessage{
+		routes:     s.servedRoutes(update.routes),
+		deletedIDs: append([]string(nil), update.deletedIDs...),
+		version:    update.version,
+		err:        update.err,
+	}
+
+	for _, r := range update.routes {
+		if s.disabled[r.Id] {
+			served.deletedIDs = append(served.deletedIDs, r.Id)
+		}
+	}
+
+	return served
+}
+
+func writePatchStatus(w io.Writer, p []patchStatus) error {
+	return json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,133 @@
+package configfilter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+const (
+	defaultPollInterval = 30 * time.Second
+	defaultPollTimeout  = 10 * time.Second
+	maxPollBackoff      = 32
+)
+
+// PollSource defines an external source of eskip routes, that the data client
+// fetches periodically, and applies as a complete replacement of the routes
+// set through the API.
+type PollSource struct {
+
+	// URL of the eskip document. When empty, no polling happens.
+	URL string
+
+	// Interval between the polls. On failures, the interval is doubled with
+	// every consecutive failure, up to 32 times the interval. Defaults to 30
+	// seconds.
+	Interval time.Duration
+
+	// Timeout of fetching the document. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+func fetchRoutes(ctx context.Context, client *http.Client, u string) ([]*eskip.Route, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", rsp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return eskip.Parse(string(b))
+}
+
+// applyPolled replaces the routes with the ones fetched from the source, after
+// validating them the same way as the routes sent to the root with PUT. It
+// returns false when the data client was closed.
+func (s *Spec) applyPolled(routes []*eskip.Route) (bool, error) {
+	f := s.newFilter()
+	req := request{method: "PUT", routes: routes}
+	if err := f.validateRoutes(req); err != nil {
+		return true, fmt.Errorf("invalid routes: %v", err)
+	}
+
+	c := make(chan response)
+	req = f.prefixRequest(req)
+	req.response = c
+	select {
+	case s.request <- req:
+	case <-s.stop:
+		return false, nil
+	}
+
+	return true, (<-c).err
+}
+
+func (s *Spec) poll(p PollSource) {
+	if p.Interval <= 0 {
+		p.Interval = defaultPollInterval
+	}
+
+	if p.Timeout <= 0 {
+		p.Timeout = defaultPollTimeout
+	}
+
+	// the pending fetch is cancelled when the data client is closed
+	client := &http.Client{Timeout: p.Timeout}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	backoff := 1
+	for {
+		select {
+		case <-s.clock.After(time.Duration(backoff) * p.Interval):
+		case <-s.stop:
+			return
+		}
+
+		routes, err := fetchRoutes(ctx, client, p.URL)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			var open bool
+			if open, err = s.applyPolled(routes); !open {
+				return
+			}
+		}
+
+		if err == nil {
+			backoff = 1
+			continue
+		}
+
+		s.log.Errorf("failed to poll routes from %s: %v", p.URL, err)
+		if backoff < maxPollBackoff {
+			backoff *= 2
+		}
+	}
+}
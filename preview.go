@@ -0,0 +1,52 @@
+package configfilter
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+func requestPreviewResult(method string, q url.Values) (bool, error) {
+	switch strings.ToLower(q.Get("previewResult")) {
+	case "", "false", "0":
+		return false, nil
+	case "true", "1":
+		if !mutatingMethod(method) {
+			return false, badRequestString("previewResult is supported only for the changes")
+		}
+
+		return true, nil
+	default:
+		return false, badRequestString("invalid value of previewResult")
+	}
+}
+
+// previewResult applies the change to the current routes, and returns the
+// resulting table, like a GET request would, then restores the routes. The
+// change is not committed, and the routing doesn't receive an update.
+func (s *Spec) previewResult(req request) response {
+	if req.txn != "" || req.trash || req.propagation != "" || req.rename != "" {
+		return response{err: badRequestString("previewResult is not supported for this request")}
+	}
+
+	prev, prevRaw, prevRawType, prevDisabled := s.routes, s.raw, s.rawType, copyDisabled(s.disabled)
+	rsp, _ := s.handle(req)
+	result := s.routes
+	s.routes, s.raw, s.rawType, s.disabled = prev, prevRaw, prevRawType, prevDisabled
+	if rsp.err != nil {
+		return response{err: rsp.err}
+	}
+
+	routes := append(append([]*eskip.Route(nil), result...), s.defaults...)
+	if req.namespace != "" {
+		routes = routesWithPrefix(result, req.namespace)
+	}
+
+	return response{
+		withContent: true,
+		routes:      routes,
+		meta:        s.routesMeta(routes),
+		version:     s.version,
+	}
+}
@@ -0,0 +1,102 @@
+package configfilter
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// operationCounters counts the API requests passed to the data client by
+// method.
+type operationCounters struct {
+	mx     sync.Mutex
+	counts map[string]int
+}
+
+type statsState struct {
+	routes       int
+	defaults     int
+	lastModified time.Time
+}
+
+func newOperationCounters() *operationCounters {
+	return &operationCounters{counts: make(map[string]int)}
+}
+
+func (c *operationCounters) inc(method string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.counts[method]++
+}
+
+func (c *operationCounters) copy() map[string]int {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	m := make(map[string]int)
+	for k, v := range c.counts {
+		m[k] = v
+	}
+
+	return m
+}
+
+func writeMetric(b *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, typ)
+}
+
+// prometheusMetrics renders the metrics in the Prometheus text format.
+func prometheusMetrics(st statsState, operations map[string]int) []byte {
+	var b bytes.Buffer
+	writeMetric(&b, "configfilter_routes", "gauge", "Number of the routes set through the API.")
+	fmt.Fprintf(&b, "configfilter_routes %d\n", st.routes)
+	writeMetric(&b, "configfilter_default_routes", "gauge", "Number of the default routes.")
+	fmt.Fprintf(&b, "configfilter_default_routes %d\n", st.defaults)
+
+	writeMetric(&b, "configfilter_operations_total", "counter", "Number of the API requests by method.")
+	var methods []string
+	for m := range operations {
+		methods = append(methods, m)
+	}
+
+	sort.Strings(methods)
+	for _, m := range methods {
+		fmt.Fprintf(&b, "configfilter_operations_total{method=%q} %d\n", m, operations[m])
+	}
+
+	writeMetric(
+		&b,
+		"configfilter_last_modified_timestamp_seconds",
+		"gauge",
+		"Time of the last change of the routes, in seconds since the Unix epoch.",
+	)
+
+	var lastModified int64
+	if !st.lastModified.IsZero() {
+		lastModified = st.lastModified.Unix()
+	}
+
+	fmt.Fprintf(&b, "configfilter_last_modified_timestamp_seconds %d\n", lastModified)
+	return b.Bytes()
+}
+
+// serveMetrics returns the route count, the operation totals and the time of
+// the last change in the Prometheus text format.
+func (f *filter) serveMetrics(w http.ResponseWriter, req request) {
+	if req.method != "GET" && req.method != "HEAD" {
+		w.Header().Set("Allow", "HEAD, GET")
+		f.serveError(w, errMethodNotSupported)
+		return
+	}
+
+	c := make(chan statsState)
+	f.stats <- c
+	b := prometheusMetrics(<-c, f.operations.copy())
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if req.method == "GET" {
+		w.Write(b)
+	}
+}
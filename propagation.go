@@ -0,0 +1,87 @@
+package configfilter
+
+import "github.com/zalando/skipper/eskip"
+
+const (
+	propagationID     = "propagation"
+	propagationPause  = "pause"
+	propagationResume = "resume"
+)
+
+// preprocessPropagation handles the requests pausing and resuming the
+// propagation of the changes to the routing, at /__config/propagation/pause
+// and /__config/propagation/resume.
+func preprocessPropagation(req request, sub string) (request, error) {
+	if sub != propagationPause && sub != propagationResume {
+		return req, errNotFound
+	}
+
+	if req.method != "POST" {
+		return req, errMethodNotSupported
+	}
+
+	req.propagation = sub
+	return req, nil
+}
+
+// mergeUpdates consolidates two consecutive updates into one.
+func mergeUpdates(a, b updateMessage) updateMessage {
+	var m updateMessage
+	m.routes = removeRoutes(a.routes, b.routes)
+	m.routes = removeRoutes(m.routes, idsToRoutes(b.deletedIDs, m.routes))
+	m.routes = append(m.routes, b.routes...)
+
+	readded := make(map[string]bool)
+	for _, r := range b.routes {
+		readded[r.Id] = true
+	}
+
+	deleted := make(map[string]bool)
+	for _, id := range append(append([]string(nil), a.deletedIDs...), b.deletedIDs...) {
+		if !readded[id] && !deleted[id] {
+			m.deletedIDs = append(m.deletedIDs, id)
+			deleted[id] = true
+		}
+	}
+
+	m.err = a.err
+	if m.err == nil {
+		m.err = b.err
+	}
+
+	return m
+}
+
+// setPropagation pauses or resumes passing the changes to the routing. While
+// paused, the changes are accepted and recorded, and they are held back until
+// resumed, when they are delivered as a single update.
+func (s *Spec) setPropagation(req request) (rsp response, update updateMessage) {
+	switch {
+	case req.propagation == propagationPause && !s.paused:
+		s.paused = true
+		s.pausedRoutes = append([]*eskip.Route(nil), s.servedRoutes(s.routes)...)
+		s.pausedVersion = s.version
+	case req.propagation == propagationResume && s.paused:
+		held := s.held
+		s.paused = false
+		s.held = updateMessage{}
+		s.pausedRoutes = nil
+		s.queueUpdate(held)
+	}
+
+	return
+}
+
+// deliveredTable returns the routes that the routing can load completely, and
+// their version. While the propagation is paused, these are the routes at the
+// time of the pause.
+func (s *Spec) deliveredTable() updateMessage {
+	if s.paused {
+		return updateMessage{
+			routes:  append([]*eskip.Route(nil), s.pausedRoutes...),
+			version: s.pausedVersion,
+		}
+	}
+
+	return updateMessage{routes: s.servedRoutes(s.routes), version: s.version}
+}
@@ -0,0 +1,108 @@
+package configfilter
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit defines how many requests are accepted in a time window.
+type RateLimit struct {
+
+	// Requests is the maximum number of requests in a window. When zero, the
+	// requests are not limited.
+	Requests int
+
+	// Window is the length of the time window. Defaults to one second.
+	Window time.Duration
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+type rateLimiter struct {
+	limit     RateLimit
+	byIP      bool
+	trustXFF  bool
+	mx        sync.Mutex
+	windows   map[string]*rateWindow
+	lastPrune time.Time
+}
+
+func newRateLimiter(l RateLimit, byIP, trustXFF bool) *rateLimiter {
+	if l.Requests <= 0 {
+		return nil
+	}
+
+	if l.Window <= 0 {
+		l.Window = time.Second
+	}
+
+	return &rateLimiter{
+		limit:    l,
+		byIP:     byIP,
+		trustXFF: trustXFF,
+		windows:  make(map[string]*rateWindow),
+	}
+}
+
+// clientIP returns the address of the client. The X-Forwarded-For header is
+// used only when it is trusted, and then its last entry, the one appended by
+// the trusted proxy.
+func clientIP(r *http.Request, trustXFF bool) string {
+	if ff := r.Header.Get("X-Forwarded-For"); trustXFF && ff != "" {
+		ips := strings.Split(ff, ",")
+		return strings.TrimSpace(ips[len(ips)-1])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.limit.Window {
+		return
+	}
+
+	for k, w := range l.windows {
+		if now.Sub(w.start) >= l.limit.Window {
+			delete(l.windows, k)
+		}
+	}
+
+	l.lastPrune = now
+}
+
+// allow returns zero when the request is accepted, otherwise the time after
+// which the client can retry.
+func (l *rateLimiter) allow(r *http.Request, now time.Time) time.Duration {
+	var key string
+	if l.byIP {
+		key = clientIP(r, l.trustXFF)
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	l.prune(now)
+	w := l.windows[key]
+	if w == nil || now.Sub(w.start) >= l.limit.Window {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+
+	if w.count >= l.limit.Requests {
+		return w.start.Add(l.limit.Window).Sub(now)
+	}
+
+	w.count++
+	return 0
+}
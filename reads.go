@@ -0,0 +1,60 @@
+package configfilter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+// routeReads holds a copy of the routes and their metadata, published by the
+// data client after every change. The individual routes are read from this
+// copy, so that the reads don't wait for the requests processed by the data
+// client, e.g. a long running update of the whole table. The writes, even of
+// the individual routes, are still processed by the data client one at a time,
+// because each of them creates a new version of the whole table.
+type routeReads struct {
+	mx     sync.RWMutex
+	routes map[string]*eskip.Route
+	meta   map[string]routeMeta
+}
+
+// publishReads replaces the copy of the routes used by the reads. The routes
+// are not changed in place by the data client, so it's enough to copy the
+// references.
+func (s *Spec) publishReads() {
+	all := s.tableCopy()
+	routes := make(map[string]*eskip.Route)
+	for _, r := range all {
+		routes[r.Id] = r
+	}
+
+	meta := s.routesMeta(all)
+
+	s.reads.mx.Lock()
+	defer s.reads.mx.Unlock()
+	s.reads.routes, s.reads.meta = routes, meta
+}
+
+// get returns an individual route. The routes already expired but not yet
+// removed by the data client are not returned.
+func (r *routeReads) get(req request, now time.Time) response {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	route, ok := r.routes[req.id]
+	if !ok {
+		return response{err: errNotFound}
+	}
+
+	m := r.meta[route.Id]
+	if !m.expires.IsZero() && !now.Before(m.expires) {
+		return response{err: errNotFound}
+	}
+
+	return response{
+		routes:      []*eskip.Route{route},
+		meta:        map[string]routeMeta{route.Id: m},
+		withContent: true,
+	}
+}
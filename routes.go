@@ -1,6 +1,16 @@
 package configfilter
 
-import "github.com/zalando/skipper/eskip"
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/zalando/skipper/eskip"
+)
 
 func uniqueRoutes(r []*eskip.Route) []*eskip.Route {
 	var u []*eskip.Route
@@ -97,3 +107,601 @@ func upsertRoutes(to, from []*eskip.Route) ([]*eskip.Route, []*eskip.Route) {
 	next := append(unchangedRoutes, upserted...)
 	return next, upserted
 }
+
+func sortByID(r []*eskip.Route) {
+	sort.Slice(r, func(i, j int) bool { return r[i].Id < r[j].Id })
+}
+
+// loopbackPath returns the path that a loopback route passes on to the
+// routing, as far as it can be told from the route itself.
+func loopbackPath(r *eskip.Route) string {
+	p := r.Path
+	for _, f := range r.Filters {
+		if f.Name != "setPath" || len(f.Args) != 1 {
+			continue
+		}
+
+		if s, ok := f.Args[0].(string); ok {
+			p = s
+		}
+	}
+
+	return p
+}
+
+func references(from, to *eskip.Route) bool {
+	if from.Id == to.Id || from.BackendType != eskip.LoopBackend {
+		return false
+	}
+
+	p := loopbackPath(from)
+	return p != "" && p == to.Path
+}
+
+// topologicalOrder orders the routes such that the routes that are the
+// targets of loopback routes precede the routes referencing them. Otherwise,
+// and in case of cycles, the routes are ordered by their ID.
+func topologicalOrder(r []*eskip.Route) []*eskip.Route {
+	r = append([]*eskip.Route(nil), r...)
+	sortByID(r)
+
+	targets := make(map[*eskip.Route]int)
+	referrers := make(map[*eskip.Route][]*eskip.Route)
+	for _, from := range r {
+		for _, to := range r {
+			if references(from, to) {
+				targets[from]++
+				referrers[to] = append(referrers[to], from)
+			}
+		}
+	}
+
+	var ordered []*eskip.Route
+	done := make(map[*eskip.Route]bool)
+	for len(ordered) < len(r) {
+		var next *eskip.Route
+		for _, ri := range r {
+			if !done[ri] && targets[ri] == 0 {
+				next = ri
+				break
+			}
+		}
+
+		if next == nil {
+			for _, ri := range r {
+				if !done[ri] {
+					next = ri
+					break
+				}
+			}
+		}
+
+		done[next] = true
+		ordered = append(ordered, next)
+		for _, ri := range referrers[next] {
+			targets[ri]--
+		}
+	}
+
+	return ordered
+}
+
+func encodeCursor(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	id, err := base64.RawURLEncoding.DecodeString(cursor)
+	return string(id), err
+}
+
+// routesPage returns at most limit routes, ordered by ID, following the ID
+// encoded in the cursor. When there are more routes, it returns the cursor
+// to the next page, too.
+func routesPage(r []*eskip.Route, cursor string, limit int) ([]*eskip.Route, string) {
+	r = append([]*eskip.Route(nil), r...)
+	sortByID(r)
+	start := sort.Search(len(r), func(i int) bool { return r[i].Id > cursor })
+	return pageFrom(r[start:], limit)
+}
+
+// orderedPage returns at most limit routes in their current order, following
+// the route with the ID encoded in the cursor. It returns false, when the route
+// of the cursor is not found, e.g. because it was deleted.
+func orderedPage(r []*eskip.Route, cursor string, limit int) ([]*eskip.Route, string, bool) {
+	var start int
+	if cursor != "" {
+		start = -1
+		for i, ri := range r {
+			if ri.Id == cursor {
+				start = i + 1
+				break
+			}
+		}
+
+		if start < 0 {
+			return nil, "", false
+		}
+	}
+
+	page, next := pageFrom(r[start:], limit)
+	return page, next, true
+}
+
+func pageFrom(r []*eskip.Route, limit int) ([]*eskip.Route, string) {
+	if len(r) == 0 {
+		return nil, ""
+	}
+
+	if len(r) <= limit {
+		return r, ""
+	}
+
+	return r[:limit], encodeCursor(r[limit-1].Id)
+}
+
+// routesETag returns an entity tag derived only from the content of the
+// routes and from which of them are disabled, independent from their order.
+func routesETag(r []*eskip.Route, disabled map[string]bool) string {
+	r = append([]*eskip.Route(nil), r...)
+	sortByID(r)
+	s := eskip.String(r...)
+	for _, ri := range r {
+		if disabled[ri.Id] {
+			s += "\ndisabled " + ri.Id
+		}
+	}
+
+	h := sha256.Sum256([]byte(s))
+	return `"` + hex.EncodeToString(h[:]) + `"`
+}
+
+// representationETag returns the entity tag of a representation of the routes,
+// extending their content hash with the hash of the namespace and the
+// requested variant, when any of them is set.
+func representationETag(contentTag, namespace, variant string) string {
+	if namespace == "" && variant == "" {
+		return contentTag
+	}
+
+	h := sha256.Sum256([]byte(namespace + "\n" + variant))
+	return strings.TrimSuffix(contentTag, `"`) + "-" + hex.EncodeToString(h[:8]) + `"`
+}
+
+// contentETag returns the content hash part of an entity tag.
+func contentETag(etag string) string {
+	if i := strings.Index(etag, "-"); i >= 0 {
+		return etag[:i] + `"`
+	}
+
+	return etag
+}
+
+// routeWeight approximates how Skipper prioritizes the routes with the same
+// path: the more predicates a route has, the earlier it is tried.
+func routeWeight(r *eskip.Route) int {
+	w := len(r.HostRegexps) + len(r.PathRegexps) + len(r.Headers) + len(r.Predicates)
+	for _, v := range r.HeaderRegexps {
+		w += len(v)
+	}
+
+	if r.Method != "" {
+		w++
+	}
+
+	return w
+}
+
+func segmentRank(s string) int {
+	switch {
+	case freeWildcard(s):
+		return 2
+	case strings.HasPrefix(s, ":"):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// comparePaths compares two path predicates in the order of Skipper's path
+// lookup: in each segment, the static names precede the named wildcards, and
+// those precede the free wildcards. The routes without a path predicate are
+// tried last.
+func comparePaths(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	sa, sb := pathSegments(a), pathSegments(b)
+	for i := 0; i < len(sa) && i < len(sb); i++ {
+		if ra, rb := segmentRank(sa[i]), segmentRank(sb[i]); ra != rb {
+			return ra - rb
+		}
+
+		if sa[i] != sb[i] {
+			return strings.Compare(sa[i], sb[i])
+		}
+	}
+
+	return len(sa) - len(sb)
+}
+
+// effectiveOrder orders the routes as Skipper's routing would try them, as far
+// as it can be told from the predicates: by the path, then by the weight, and
+// finally by the ID.
+func effectiveOrder(r []*eskip.Route) []*eskip.Route {
+	r = append([]*eskip.Route(nil), r...)
+	sort.Slice(r, func(i, j int) bool {
+		if c := comparePaths(r[i].Path, r[j].Path); c != 0 {
+			return c < 0
+		}
+
+		if wi, wj := routeWeight(r[i]), routeWeight(r[j]); wi != wj {
+			return wi > wj
+		}
+
+		return r[i].Id < r[j].Id
+	})
+
+	return r
+}
+
+// sortRoutes orders the routes by their ID, by their backend, or by the count
+// of their predicates, ascending. The routes with the same backend or the same
+// count of predicates are ordered by their ID.
+func sortRoutes(r []*eskip.Route, by string) []*eskip.Route {
+	r = append([]*eskip.Route(nil), r...)
+	sortByID(r)
+	switch by {
+	case orderBackend:
+		sort.SliceStable(r, func(i, j int) bool { return jsonBackend(r[i]) < jsonBackend(r[j]) })
+	case orderPredicates:
+		sort.SliceStable(r, func(i, j int) bool {
+			return len(jsonPredicates(r[i])) < len(jsonPredicates(r[j]))
+		})
+	}
+
+	return r
+}
+
+func pathSegments(p string) []string {
+	return strings.Split(strings.Trim(p, "/"), "/")
+}
+
+func freeWildcard(segment string) bool {
+	return strings.HasPrefix(segment, "*")
+}
+
+// pathsOverlap tells whether two path predicates, with possible wildcards,
+// can match the same request path.
+func pathsOverlap(a, b string) bool {
+	sa, sb := pathSegments(a), pathSegments(b)
+	if len(sa) > len(sb) {
+		sa, sb = sb, sa
+	}
+
+	for i, si := range sa {
+		if freeWildcard(si) || freeWildcard(sb[i]) {
+			return true
+		}
+
+		if strings.HasPrefix(si, ":") || strings.HasPrefix(sb[i], ":") {
+			continue
+		}
+
+		if si != sb[i] {
+			return false
+		}
+	}
+
+	return len(sa) == len(sb) || len(sb) == len(sa)+1 && freeWildcard(sb[len(sa)])
+}
+
+func backendType(r *eskip.Route) string {
+	switch {
+	case r.Shunt || r.BackendType == eskip.ShuntBackend:
+		return backendShunt
+	case r.BackendType == eskip.LoopBackend:
+		return backendLoopback
+	default:
+		return backendNetwork
+	}
+}
+
+func routesByBackend(routes []*eskip.Route, bt string) []*eskip.Route {
+	var filtered []*eskip.Route
+	for _, r := range routes {
+		if backendType(r) == bt {
+			filtered = append(filtered, r)
+		}
+	}
+
+	return filtered
+}
+
+// routeMethods returns the HTTP methods that the route is restricted to, or
+// nil when it accepts all the methods.
+func routeMethods(r *eskip.Route) []string {
+	var m []string
+	if r.Method != "" {
+		m = append(m, strings.ToUpper(r.Method))
+	}
+
+	for _, p := range r.Predicates {
+		if p.Name != "Methods" {
+			continue
+		}
+
+		for _, a := range p.Args {
+			if s, ok := a.(string); ok {
+				m = append(m, strings.ToUpper(s))
+			}
+		}
+	}
+
+	return m
+}
+
+// routesByMethod returns the routes that accept the HTTP method, including
+// the routes without a method predicate.
+func routesByMethod(routes []*eskip.Route, method string) []*eskip.Route {
+	var filtered []*eskip.Route
+	for _, r := range routes {
+		m := routeMethods(r)
+		if len(m) == 0 {
+			filtered = append(filtered, r)
+			continue
+		}
+
+		for _, mi := range m {
+			if mi == method {
+				filtered = append(filtered, r)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
+// mergeDocuments merges the routes of an overlay document into the routes of a
+// base document. The routes with the same ID but different definitions are
+// resolved by the strategy, and their IDs are returned.
+func mergeDocuments(base, overlay []*eskip.Route, strategy string) ([]*eskip.Route, []string) {
+	conflicting := routesToIDs(changedRoutes(base, overlay))
+	if strategy == mergeBaseWins {
+		return append(base, removeRoutes(overlay, base)...), conflicting
+	}
+
+	return append(removeRoutes(base, overlay), overlay...), conflicting
+}
+
+func routesWithPrefix(routes []*eskip.Route, prefix string) []*eskip.Route {
+	var matching []*eskip.Route
+	for _, r := range routes {
+		if strings.HasPrefix(r.Id, prefix) {
+			matching = append(matching, r)
+		}
+	}
+
+	return matching
+}
+
+// routesMatching returns the routes whose printed definition, including the
+// ID, contains the search text, ignoring case.
+func routesMatching(routes []*eskip.Route, q string) []*eskip.Route {
+	q = strings.ToLower(q)
+	var matching []*eskip.Route
+	for _, r := range routes {
+		if strings.Contains(strings.ToLower(eskip.Print(false, r)), q) {
+			matching = append(matching, r)
+		}
+	}
+
+	return matching
+}
+
+// redactRoutes returns copies of the routes where the network backends, or
+// only the credentials in the backend URLs, are replaced.
+func redactRoutes(routes []*eskip.Route, mode string) []*eskip.Route {
+	var redacted []*eskip.Route
+	for _, r := range routes {
+		if backendType(r) != backendNetwork {
+			redacted = append(redacted, r)
+			continue
+		}
+
+		c := *r
+		switch mode {
+		case redactBackends:
+			c.Backend = redactedBackend
+		case redactCredentials:
+			if u, err := url.Parse(r.Backend); err == nil && u.User != nil {
+				u.User = url.User(redactedBackend)
+				c.Backend = u.String()
+			}
+		}
+
+		redacted = append(redacted, &c)
+	}
+
+	return redacted
+}
+
+// mergeEntry is a predicate or a filter to be merged.
+type mergeEntry struct {
+	name string
+	args []interface{}
+}
+
+// mergedItem points to a predicate or a filter of the merged route, either in
+// the current route or in the patch.
+type mergedItem struct {
+	patch bool
+	index int
+}
+
+// mergeItems merges the predicates or the filters of a patch into the current
+// ones. The ones in the patch replace the ones with the same name in the
+// current route, at their position, the new ones are appended, and the others
+// are kept. When the current route has multiple ones with the same name, e.g.
+// multiple setRequestHeader filters, they are told apart by their first
+// argument, and merged separately.
+func mergeItems(current, patch []mergeEntry) []mergedItem {
+	count := make(map[string]int)
+	for _, c := range current {
+		count[c.name]++
+	}
+
+	key := func(e mergeEntry) string {
+		if count[e.name] < 2 || len(e.args) == 0 {
+			return e.name
+		}
+
+		return fmt.Sprintf("%s(%v", e.name, e.args[0])
+	}
+
+	var merged []mergedItem
+	used := make(map[string]bool)
+	for i, ci := range current {
+		c := key(ci)
+		var replaced bool
+		for j, p := range patch {
+			if key(p) == c {
+				replaced = true
+				if !used[c] {
+					merged = append(merged, mergedItem{patch: true, index: j})
+				}
+			}
+		}
+
+		if replaced {
+			used[c] = true
+		} else {
+			merged = append(merged, mergedItem{index: i})
+		}
+	}
+
+	for j, p := range patch {
+		if !used[key(p)] {
+			merged = append(merged, mergedItem{patch: true, index: j})
+		}
+	}
+
+	return merged
+}
+
+func mergePredicates(current, patch []*eskip.Predicate) []*eskip.Predicate {
+	entries := func(p []*eskip.Predicate) []mergeEntry {
+		var e []mergeEntry
+		for _, pi := range p {
+			e = append(e, mergeEntry{name: pi.Name, args: pi.Args})
+		}
+
+		return e
+	}
+
+	var merged []*eskip.Predicate
+	for _, m := range mergeItems(entries(current), entries(patch)) {
+		if m.patch {
+			merged = append(merged, patch[m.index])
+		} else {
+			merged = append(merged, current[m.index])
+		}
+	}
+
+	return merged
+}
+
+func mergeFilters(current, patch []*eskip.Filter) []*eskip.Filter {
+	entries := func(f []*eskip.Filter) []mergeEntry {
+		var e []mergeEntry
+		for _, fi := range f {
+			e = append(e, mergeEntry{name: fi.Name, args: fi.Args})
+		}
+
+		return e
+	}
+
+	var merged []*eskip.Filter
+	for _, m := range mergeItems(entries(current), entries(patch)) {
+		if m.patch {
+			merged = append(merged, patch[m.index])
+		} else {
+			merged = append(merged, current[m.index])
+		}
+	}
+
+	return merged
+}
+
+// mergeRoute returns a copy of the current route, where the predicates and the
+// filters mentioned in the patch are replaced or added, while the others are
+// kept. Since every route expression has a backend, the backend is always
+// taken from the patch.
+func mergeRoute(current, patch *eskip.Route) *eskip.Route {
+	m := *current
+	if patch.Path != "" {
+		m.Path = patch.Path
+	}
+
+	if len(patch.HostRegexps) > 0 {
+		m.HostRegexps = patch.HostRegexps
+	}
+
+	if len(patch.PathRegexps) > 0 {
+		m.PathRegexps = patch.PathRegexps
+	}
+
+	if patch.Method != "" {
+		m.Method = patch.Method
+	}
+
+	if len(patch.Headers) > 0 {
+		m.Headers = make(map[string]string)
+		for k, v := range current.Headers {
+			m.Headers[k] = v
+		}
+
+		for k, v := range patch.Headers {
+			m.Headers[k] = v
+		}
+	}
+
+	if len(patch.HeaderRegexps) > 0 {
+		m.HeaderRegexps = make(map[string][]string)
+		for k, v := range current.HeaderRegexps {
+			m.HeaderRegexps[k] = v
+		}
+
+		for k, v := range patch.HeaderRegexps {
+			m.HeaderRegexps[k] = v
+		}
+	}
+
+	m.Predicates = mergePredicates(current.Predicates, patch.Predicates)
+	m.Filters = mergeFilters(current.Filters, patch.Filters)
+	m.Shunt = patch.Shunt
+	m.BackendType = patch.BackendType
+	m.Backend = patch.Backend
+	return &m
+}
+
+// stringSet returns nil for an empty list.
+func stringSet(s []string) map[string]bool {
+	if len(s) == 0 {
+		return nil
+	}
+
+	m := make(map[string]bool)
+	for _, si := range s {
+		m[si] = true
+	}
+
+	return m
+}
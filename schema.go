@@ -0,0 +1,61 @@
+package configfilter
+
+import "net/http"
+
+const schemaID = "schema"
+
+// routeSchema describes the routes accepted in JSON, as parsed by parseJSON. The
+// read-only fields returned by GET are accepted, but ignored.
+const routeSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "configfilter routes",
+  "definitions": {
+    "expression": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string", "minLength": 1},
+        "args": {"type": "array"}
+      },
+      "required": ["name"]
+    },
+    "route": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "string", "pattern": "^[a-zA-Z_][a-zA-Z0-9_]*$"},
+        "predicates": {"type": "array", "items": {"$ref": "#/definitions/expression"}},
+        "filters": {"type": "array", "items": {"$ref": "#/definitions/expression"}},
+        "backend": {
+          "type": "string",
+          "description": "an HTTP URL, <shunt> or <loopback>",
+          "minLength": 1
+        },
+        "disabled": {"type": "boolean", "readOnly": true},
+        "createdAt": {"type": "string", "format": "date-time", "readOnly": true},
+        "updatedAt": {"type": "string", "format": "date-time", "readOnly": true},
+        "lastModifiedBy": {"type": "string", "readOnly": true},
+        "weight": {"type": "integer", "readOnly": true},
+        "traffic": {"type": "object", "readOnly": true}
+      },
+      "required": ["backend"]
+    }
+  },
+  "oneOf": [
+    {"$ref": "#/definitions/route"},
+    {"type": "array", "items": {"$ref": "#/definitions/route"}}
+  ]
+}
+`
+
+// serveSchema responds with the JSON Schema of the routes accepted in JSON.
+func (f *filter) serveSchema(w http.ResponseWriter, req request) {
+	if req.method != "GET" && req.method != "HEAD" {
+		w.Header().Set("Allow", "HEAD, GET")
+		f.serveError(w, errMethodNotSupported)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if req.method == "GET" {
+		w.Write([]byte(routeSchema))
+	}
+}
@@ -0,0 +1,65 @@
+package configfilter
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+const sectionsByPrefix = "byPrefix"
+
+func requestSections(s string) (string, error) {
+	switch s {
+	case "", sectionsByPrefix:
+		return s, nil
+	default:
+		return "", badRequestString("invalid sections")
+	}
+}
+
+// idPrefix returns the part of the ID before the first underscore, ignoring
+// the leading underscores. IDs without an underscore have no prefix.
+func idPrefix(id string) string {
+	trimmed := strings.TrimLeft(id, "_")
+	i := strings.Index(trimmed, "_")
+	if i <= 0 {
+		return ""
+	}
+
+	return id[:len(id)-len(trimmed)+i]
+}
+
+// writeSections writes the routes in eskip format, grouped by the prefix of
+// their ID, ordered by the prefix, with a comment header for every group. The
+// routes without a prefix are written last, in a group named other.
+func writeSections(w io.Writer, req request, rsp response) error {
+	groups := make(map[string][]*eskip.Route)
+	var prefixes []string
+	for _, r := range rsp.routes {
+		p := idPrefix(r.Id)
+		if _, ok := groups[p]; !ok {
+			prefixes = append(prefixes, p)
+		}
+
+		groups[p] = append(groups[p], r)
+	}
+
+	sort.Slice(prefixes, func(i, j int) bool {
+		return prefixes[i] != "" && (prefixes[j] == "" || prefixes[i] < prefixes[j])
+	})
+
+	var s []string
+	for _, p := range prefixes {
+		name := p
+		if name == "" {
+			name = "other"
+		}
+
+		s = append(s, "// "+name+"\n\n"+printExpanded(req.pretty, groups[p], rsp.expanded, rsp.meta))
+	}
+
+	_, err := w.Write([]byte(strings.Join(s, ";\n\n")))
+	return err
+}
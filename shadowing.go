@@ -0,0 +1,207 @@
+package configfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+const analyzeShadowing = "shadowing"
+
+// shadowedRoute reports a route that can never match, because another route
+// matches every request that it would match.
+type shadowedRoute struct {
+	ID string `json:"id"`
+	By string `json:"shadowedBy"`
+}
+
+func requestAnalyze(method, id, s string) (string, error) {
+	switch s {
+	case "":
+		return "", nil
+	case analyzeShadowing:
+		if id != "" || method != "GET" && method != "HEAD" {
+			return "", badRequestString("analysis is supported only for getting all the routes")
+		}
+
+		return s, nil
+	default:
+		return "", badRequestString("invalid analysis")
+	}
+}
+
+// pathTreeKey returns the key of the path predicate in Skipper's path tree,
+// where the names of the wildcards don't matter. Skipper chooses from the
+// routes with the most specific key matching the request, and a route with
+// a different key never takes the requests of another one.
+func pathTreeKey(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		switch {
+		case freeWildcard(s):
+			segments[i] = "*"
+		case strings.HasPrefix(s, ":"):
+			segments[i] = ":"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func containsStrings(a, b []string) bool {
+	set := stringSet(b)
+	for _, s := range a {
+		if !set[s] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containsMethods tells whether every method accepted by b is accepted by a.
+func containsMethods(a, b *eskip.Route) bool {
+	var n int
+	if a.Method != "" {
+		n++
+	}
+
+	for _, p := range a.Predicates {
+		if p.Name == "Methods" {
+			n++
+		}
+	}
+
+	sa := methodSets(a)
+	if len(sa) != n {
+		// unknown arguments, not checked
+		return false
+	}
+
+	sb := methodSets(b)
+	for _, s := range sa {
+		var contained bool
+		for _, sbi := range sb {
+			contained = true
+			for m := range sbi {
+				if !s[m] {
+					contained = false
+					break
+				}
+			}
+
+			if contained {
+				break
+			}
+		}
+
+		if !contained {
+			return false
+		}
+	}
+
+	return true
+}
+
+func predicateStrings(r *eskip.Route) []string {
+	var s []string
+	for _, p := range r.Predicates {
+		if p.Name != "Methods" {
+			s = append(s, fmt.Sprintf("%s%v", p.Name, p.Args))
+		}
+	}
+
+	return s
+}
+
+// containsMatcher tells whether the predicates of the route a are contained by
+// the predicates of the route b. The unknown predicates are compared by their
+// name and arguments.
+func containsMatcher(a, b *eskip.Route) bool {
+	if !containsStrings(a.HostRegexps, b.HostRegexps) ||
+		!containsStrings(a.PathRegexps, b.PathRegexps) ||
+		!containsMethods(a, b) ||
+		!containsStrings(predicateStrings(a), predicateStrings(b)) {
+		return false
+	}
+
+	for k, v := range a.Headers {
+		if bv, ok := b.Headers[k]; !ok || bv != v {
+			return false
+		}
+	}
+
+	for k, v := range a.HeaderRegexps {
+		if !containsStrings(v, b.HeaderRegexps[k]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameMatcher tells whether the routes a and b match the same requests with
+// the same predicates, ignoring the names of the path wildcards. The check is
+// strict: Skipper has no defined order for the routes with the same weight,
+// so a route is shadowed for certain only by an identical matcher, when it
+// doesn't matter which of them the routing tries first.
+func sameMatcher(a, b *eskip.Route) bool {
+	return pathTreeKey(a.Path) == pathTreeKey(b.Path) &&
+		containsMatcher(a, b) &&
+		containsMatcher(b, a)
+}
+
+// shadowedRoutes returns the routes that are shadowed by another route. Of the
+// routes with the same matcher, all but the first one are reported.
+func shadowedRoutes(routes []*eskip.Route) []shadowedRoute {
+	var shadowed []shadowedRoute
+	for j, b := range routes {
+		for _, a := range routes[:j] {
+			if sameMatcher(a, b) {
+				shadowed = append(shadowed, shadowedRoute{ID: b.Id, By: a.Id})
+				break
+			}
+		}
+	}
+
+	return shadowed
+}
+
+// serveShadowing responds with the shadowed routes, one per line in the
+// format of <id> shadowed by <id>, or as a JSON array of objects. The disabled
+// routes are not analyzed, because the routing doesn't serve them.
+func (f *filter) serveShadowing(w http.ResponseWriter, req request, rsp response) {
+	var served []*eskip.Route
+	for _, r := range rsp.routes {
+		if !rsp.meta[r.Id].disabled {
+			served = append(served, r)
+		}
+	}
+
+	shadowed := shadowedRoutes(served)
+	format, ct := decideContentType(req.accept)
+	if format != responseFormatJSON {
+		ct = contentTypeText
+	}
+
+	w.Header().Set("Content-Type", ct)
+	if req.method == "HEAD" {
+		return
+	}
+
+	if format == responseFormatJSON {
+		if shadowed == nil {
+			shadowed = []shadowedRoute{}
+		}
+
+		json.NewEncoder(w).Encode(shadowed)
+		return
+	}
+
+	for _, s := range shadowed {
+		fmt.Fprintf(w, "%s shadowed by %s\n", s.ID, s.By)
+	}
+}
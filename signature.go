@@ -0,0 +1,57 @@
+package configfilter
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// verifySignature checks the hex encoded HMAC-SHA256 signature of the request
+// body, sent in the X-Signature header, optionally prefixed with sha256=. The
+// body is restored for parsing.
+func verifySignature(key []byte, r *http.Request) error {
+	var (
+		b   []byte
+		err error
+	)
+
+	if r.Body != nil {
+		if b, err = ioutil.ReadAll(r.Body); err != nil {
+			return err
+		}
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return checkSignature(key, r.Header.Get("X-Signature"), b)
+}
+
+// verifyCreateIfMissing checks the signature of the GET requests creating a
+// route, where the signed content is the value of the createIfMissing query
+// parameter.
+func verifyCreateIfMissing(key []byte, r *http.Request) error {
+	return checkSignature(key, r.Header.Get("X-Signature"), []byte(r.URL.Query().Get("createIfMissing")))
+}
+
+func checkSignature(key []byte, header string, content []byte) error {
+	s := strings.TrimPrefix(strings.TrimSpace(header), "sha256=")
+	if s == "" {
+		return errUnauthorized
+	}
+
+	signature, err := hex.DecodeString(s)
+	if err != nil {
+		return errUnauthorized
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(content)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return errUnauthorized
+	}
+
+	return nil
+}
@@ -2,6 +2,10 @@ package configfilter
 
 import (
 	"errors"
+	"net/http"
+	"strings"
+	"time"
+
 	"github.com/zalando/skipper/eskip"
 	"github.com/zalando/skipper/filters"
 	"github.com/zalando/skipper/logging"
@@ -18,6 +22,41 @@ const (
 	DefaultRoot = "/" + DefaultSelfID
 )
 
+const (
+	defaultHistorySize = 32
+
+	orderTopological = "topo"
+	orderEffective   = "effective"
+	orderID          = "id"
+	orderBackend     = "backend"
+	orderPredicates  = "predicates"
+	formatZip        = "zip"
+	formatJSON       = "json"
+	formatSkipper    = "skipper-json"
+
+	backendNetwork  = "network"
+	backendShunt    = "shunt"
+	backendLoopback = "loopback"
+
+	redactBackends    = "backends"
+	redactCredentials = "credentials"
+	redactedBackend   = "REDACTED"
+
+	contentTypeText  = "text/plain; charset=utf-8"
+	contentTypeEskip = "application/eskip; charset=utf-8"
+	contentTypeJSON  = "application/json; charset=utf-8"
+	contentTypeHTML  = "text/html; charset=utf-8"
+
+	defaultIDListSeparator = ","
+
+	namespaceID        = "ns"
+	namespaceSeparator = "__"
+
+	mergeOverlayWins     = "overlay-wins"
+	mergeBaseWins        = "base-wins"
+	mergeErrorOnConflict = "error-on-conflict"
+)
+
 type responseFormat int
 
 const (
@@ -25,6 +64,9 @@ const (
 	responseFormatText responseFormat = 1 << iota
 	responseFormatEskip
 	responseFormatJSON
+	responseFormatZip
+	responseFormatHTML
+	responseFormatSkipperJSON
 )
 
 // Options is used to provide initialization options for the config filter.
@@ -39,6 +81,264 @@ type Options struct {
 	// wildcard called routeid, e.g. Path("/__config/:routeid").
 	DefaultRoutes []*eskip.Route
 
+	// FilterName sets the name of the config filter in eskip documents. Defaults
+	// to "config". When the DefaultRoutes are not set, the default API routes
+	// reference the filter by this name.
+	//
+	// It allows using multiple data clients with separate routing tables in the
+	// same Skipper process. In this case, each of them needs to be initialized with
+	// default routes of its own, where the filter of the API routes is referenced
+	// by the name set here, and the paths of the API routes are distinct, e.g.
+	//
+	// 	teamA: Path("/team-a") -> configA() -> <shunt>;
+	// 	teamASingleRoute: Path("/team-a/:routeid") -> configA() -> <shunt>
+	FilterName string
+
+	// NoContentOnWrite makes the API respond with 204 No Content to the successful
+	// requests changing the routing, instead of 200 OK. Clients can request the
+	// same for individual requests with the Prefer: return=minimal header.
+	NoContentOnWrite bool
+
+	// ProtectSelfPath, when set, makes the API reject the routes whose path
+	// predicate would collide with the path of a default route, typically one of
+	// the API endpoints. It prevents locking out the API by accident.
+	ProtectSelfPath bool
+
+	// Authorize, when set, is called for every API request, after the request was
+	// parsed, with the HTTP method and the ID of the individual route (empty for
+	// the API root). When it returns an error, the request is rejected with 403
+	// Forbidden, or, if the error has a StatusCode() int method, with the status
+	// code returned by it.
+	//
+	// Authentication can be done by Skipper filters preceding the config filter in
+	// the API routes.
+	Authorize func(r *http.Request, method, id string) error
+
+	// AuthorizeRoutes, when set, is called for the requests changing the
+	// routing, after the routes in the request were parsed, and before they are
+	// applied. It receives the user name from the basic authorization of the
+	// request, the routes to be set, and the IDs of the routes to be deleted.
+	// When it returns an error, the request is rejected with 403 Forbidden, and
+	// the error message in the response body. For the partial changes sent with
+	// PATCH as JSON, it receives the resulting routes, after the changes were
+	// applied to the current routes.
+	AuthorizeRoutes func(principal string, routes []*eskip.Route, deletedIDs []string) error
+
+	// ReadRateLimit, when set, limits the number of GET and HEAD requests accepted
+	// by the API. The requests exceeding the limit are rejected with 429 Too Many
+	// Requests. It is independent from the other API requests.
+	ReadRateLimit RateLimit
+
+	// RateLimitByIP makes the rate limits apply to every client separately, by
+	// their IP address taken from the remote address of the request.
+	RateLimitByIP bool
+
+	// TrustForwardedFor makes the rate limits by IP take the client address
+	// from the last entry of the X-Forwarded-For header, when it is set. Only
+	// enable it when the API is reachable only through a trusted proxy that
+	// appends the address of the client to the header.
+	TrustForwardedFor bool
+
+	// WaitForReady, when set, makes the API reject the requests that change the
+	// routing with 503 Service Unavailable, until the routing has loaded the
+	// initial routes from the data client.
+	WaitForReady bool
+
+	// Clock is used by the data client to get the current time and to schedule
+	// timers. Defaults to the system clock.
+	Clock Clock
+
+	// HistorySize sets how many versions of the routing table are retained in
+	// memory, e.g. to serve the changes since a given version. Defaults to 32.
+	HistorySize int
+
+	// Debug, when set, enables the GET /__config/debug endpoint, returning the
+	// internal state of the data client as JSON, e.g. whether there is an update
+	// pending to be consumed by the routing.
+	Debug bool
+
+	// IDPrefix, when set, is prepended to the IDs of the routes set through the
+	// API, and it is stripped from the IDs in the responses. It allows multiple
+	// data clients in the same Skipper instance without ID collisions. The IDs of
+	// the default routes are not prefixed.
+	IDPrefix string
+
+	// Compact, when set, makes the API omit pretty printing by default. The
+	// pretty query parameter overrides it in each request.
+	Compact bool
+
+	// PollSource, when its URL is set, makes the data client fetch the routes
+	// periodically from an external source. The fetched routes are validated the
+	// same way as the routes sent to the API.
+	PollSource PollSource
+
+	// AllowMethodOverride, when set, makes the API accept POST requests with the
+	// X-HTTP-Method-Override header, and handle them with the method in the
+	// header, e.g. for clients behind proxies that block PATCH and DELETE.
+	AllowMethodOverride bool
+
+	// SnapshotInterval and SnapshotFunc, when both set, make the data client
+	// call SnapshotFunc periodically with the complete routing table, e.g. for
+	// scheduled backups. The function is called from a separate goroutine, and
+	// it must not change the routes.
+	SnapshotInterval time.Duration
+	SnapshotFunc     func([]*eskip.Route)
+
+	// MaxConcurrentRequests limits how many API requests can be handled
+	// concurrently. The requests exceeding the limit are rejected with 503
+	// Service Unavailable. When zero, the concurrent requests are not limited.
+	MaxConcurrentRequests int
+
+	// KeepRawDocument, when set, makes the data client retain the last document
+	// applied with PUT or POST to the root, as it was sent, and return it on GET
+	// requests with the query parameter ?raw=true, until the routes are changed
+	// otherwise.
+	KeepRawDocument bool
+
+	// OnChange, when set, is called with the changed routes and the IDs of the
+	// deleted routes after every change, e.g. to persist the changes. It is
+	// called in the background, in the order of the changes, and the changes
+	// not yet passed to it when the data client is closed are dropped. When it
+	// fails, it is retried RetryAttempts times, waiting RetryBackoff before the
+	// first retry, and doubling it before every further one.
+	OnChange func(routes []*eskip.Route, deletedIDs []string) error
+
+	// RetryAttempts sets how many times OnChange is retried. Defaults to 3.
+	RetryAttempts int
+
+	// RetryBackoff sets the initial wait between the retries of OnChange.
+	// Defaults to 100ms.
+	RetryBackoff time.Duration
+
+	// TrashSize, when greater than zero, makes the data client keep up to this
+	// number of the routes deleted with DELETE requests, that can be listed
+	// and restored through the API.
+	TrashSize int
+
+	// TrashMaxAge, when set, limits how long the deleted routes are kept in
+	// the trash.
+	TrashMaxAge time.Duration
+
+	// SigningKey, when set, makes the API require that the PUT, POST, PATCH
+	// and DELETE requests carry the hex encoded HMAC-SHA256 signature of the
+	// request body, created with this key, in the X-Signature header. The
+	// requests with a missing or invalid signature are rejected with 401. The
+	// GET requests with createIfMissing need the signature of the value of the
+	// createIfMissing query parameter.
+	SigningKey []byte
+
+	// LenientParse, when set, makes the API retry parsing the rejected eskip
+	// documents after dropping the redundant separators, e.g. repeated
+	// semicolons or commas before a closing parenthesis.
+	LenientParse bool
+
+	// TransactionTimeout sets how long a transaction started through the API
+	// can stage routes before it is committed. Defaults to 5 minutes.
+	TransactionTimeout time.Duration
+
+	// IdempotencyTTL sets how long the results of the changes sent with an
+	// Idempotency-Key header are kept, and returned to the retries with the
+	// same key, without applying the change again. Defaults to 10 minutes.
+	IdempotencyTTL time.Duration
+
+	// DuplicateSuppressionWindow, when set, makes the API skip applying a
+	// complete routing table sent to the root with PUT or POST, when the same
+	// document was applied within the window, and the routes were not changed
+	// since then, and respond with 304 Not Modified. It protects the routing
+	// from the churn caused by clients repeating the same submission.
+	DuplicateSuppressionWindow time.Duration
+
+	// MirrorURL, when set, makes the data client forward every change to the
+	// config API of a peer, at the root path in the URL, e.g. for active/passive
+	// replication. The changes are forwarded in the background, in the same
+	// way as OnChange is called, but retried independently from it, and the
+	// failures are only logged. The peer receives the changes as the routing
+	// does, where disabling a route deletes it, and without the IDPrefix. The
+	// forwarding requests time out after 30 seconds.
+	MirrorURL string
+
+	// IDListSeparator sets the separator of the route IDs, when deleting them
+	// from the root with a list of IDs, e.g. a newline or a semicolon.
+	// Defaults to a comma.
+	IDListSeparator string
+
+	// InitialRoutes are loaded at startup as the routes set through the API,
+	// so that the API starts with a populated table. Unlike the default routes,
+	// they can be changed and deleted. The routes need an ID, and the ones with
+	// the same ID as a default route are ignored.
+	InitialRoutes []*eskip.Route
+
+	// JSONOptions control the field names and the omitted fields of the
+	// routes returned in JSON.
+	JSONOptions JSONOptions
+
+	// LowercaseIDs, when set, normalizes the IDs of the routes received
+	// through the API, and the IDs in the paths, to lowercase, so that e.g.
+	// Foo and foo are the same route. The IDs of the default routes are not
+	// changed.
+	LowercaseIDs bool
+
+	// ValidateTable, when set, is called with the complete routing table,
+	// including the default routes, before the routes set with PUT or POST to
+	// the root, or changed with a JSON array of changes sent with PATCH to the
+	// root, are accepted, e.g. to check that the table builds in a throwaway
+	// routing instance. When it fails, the previous table is kept,
+	// and the request is rejected with 422 Unprocessable Entity.
+	ValidateTable func([]*eskip.Route) error
+
+	// AllowedPredicates, when set, restricts the predicates that the routes
+	// received through the API can use, e.g. Path or Header. The default routes
+	// are exempt.
+	AllowedPredicates []string
+
+	// DeniedPredicates lists the predicates that the routes received through
+	// the API cannot use, e.g. True or Host. The default routes are exempt.
+	DeniedPredicates []string
+
+	// FilterArgValidators, when set, validate the arguments of the filters in
+	// the routes received through the API, by the filter name, e.g. to limit the
+	// rate in the ratelimit filter. The validators are called for every
+	// occurrence of the filter, and when one returns an error, the request is
+	// rejected with 400 Bad Request. The default routes are exempt.
+	FilterArgValidators map[string]func([]interface{}) error
+
+	// RejectUnreachable, when set, makes the API reject the routes whose
+	// predicates contradict each other, with 400 Bad Request, because they can
+	// never match, e.g. Method("GET") && Methods("POST", "PUT"). The check is
+	// conservative, it doesn't detect every contradiction.
+	RejectUnreachable bool
+
+	// ResponseHeaders are set in every response of the API, including the
+	// error responses, e.g. X-Content-Type-Options: nosniff. The headers set
+	// by the API itself take precedence.
+	ResponseHeaders http.Header
+
+	// FilterRegistry, when set, is used to validate the filters of the routes
+	// received through the API. Routes with unknown filters are rejected with
+	// 422 Unprocessable Entity.
+	FilterRegistry filters.Registry
+
+	// NotModifiedOnNoop, when set, makes the API respond to the changes that
+	// leave the routes as they are with 304 Not Modified, e.g. when PUTting a
+	// route identical to the stored one. No update is sent to the routing in
+	// this case either way.
+	NotModifiedOnNoop bool
+
+	// Metrics, when set, receives how long the accepted changes wait until the
+	// routing consumes them, the pending time as the gauge
+	// configfilter.update.pending, and the total lag, when consumed, as the
+	// measurement configfilter.update.lag.
+	Metrics Metrics
+
+	// MetricsInterval sets how often the pending time is reported while a
+	// change waits for the routing. Defaults to 1 second.
+	MetricsInterval time.Duration
+
+	// Traffic, when set, provides the statistics of the live traffic served by
+	// the routes, returned in JSON when the query parameter ?withTraffic=true is
+	// set.
+	Traffic TrafficProvider
+
 	log logging.Logger
 }
 
@@ -46,19 +346,150 @@ type Options struct {
 // data client for the routing table accepts route updates through an API served
 // by itself as a filter.
 type Spec struct {
-	defaults []*eskip.Route
-	log      logging.Logger
-	routes   []*eskip.Route
-	request  chan request
-	getAll   chan (chan<- updateMessage)
-	update   chan updateMessage
-	stop     chan struct{}
+	name           string
+	defaults       []*eskip.Route
+	log            logging.Logger
+	clock          Clock
+	authorize      func(*http.Request, string, string) error
+	authorizeRts   func(string, []*eskip.Route, []string) error
+	readLimit      *rateLimiter
+	waitReady      bool
+	noContent      bool
+	protectSelf    bool
+	idPrefix       string
+	compact        bool
+	override       bool
+	inflight       chan struct{}
+	keepRaw        bool
+	raw            []byte
+	rawType        string
+	onChange       func([]*eskip.Route, []string) error
+	mirror         func([]*eskip.Route, []string) error
+	retryAttempts  int
+	retryBackoff   time.Duration
+	hooks          *hookQueue
+	metrics        Metrics
+	gaugeInterval  time.Duration
+	traffic        TrafficProvider
+	pendingSince   time.Time
+	pendingTick    <-chan time.Time
+	disabled       map[string]bool
+	trash          []trashedRoute
+	trashSize      int
+	trashMaxAge    time.Duration
+	signingKey     []byte
+	lenient        bool
+	registry       filters.Registry
+	rspHeaders     http.Header
+	rejectUnreach  bool
+	idSeparator    string
+	jsonOptions    JSONOptions
+	lowercaseIDs   bool
+	validateTable  func([]*eskip.Route) error
+	allowedPreds   map[string]bool
+	deniedPreds    map[string]bool
+	filterArgs     map[string]func([]interface{}) error
+	noopUnchanged  bool
+	transactions   map[string]*transaction
+	idempotent     map[string]idempotentResult
+	idempotencyTTL time.Duration
+	dupWindow      time.Duration
+	lastDoc        string
+	lastDocApplied time.Time
+	lastDocVer     int
+	txnTimeout     time.Duration
+	ready          bool
+	routes         []*eskip.Route
+	meta           map[string]routeMeta
+	version        int
+	history        []snapshot
+	historySize    int
+	lastModified   time.Time
+	started        time.Time
+	expiry         <-chan time.Time
+	nextExpiry     time.Time
+	updateRelay    chan<- updateMessage
+	updateToSend   updateMessage
+	paused         bool
+	held           updateMessage
+	pausedRoutes   []*eskip.Route
+	pausedVersion  int
+	sequence       int
+	pending        int
+	delivered      int
+	lastErr        error
+	reads          *routeReads
+	request        chan request
+	getAll         chan (chan<- updateMessage)
+	update         chan updateMessage
+	debug          chan (chan<- debugState)
+	applied        chan (chan<- appliedState)
+	status         chan (chan<- statusState)
+	stats          chan (chan<- statsState)
+	operations     *operationCounters
+	table          chan (chan<- []*eskip.Route)
+	stop           chan struct{}
+}
+
+// debugState is returned by the debug endpoint. It must not contain the routes
+// themselves, only counters and timestamps.
+type debugState struct {
+	Ready         bool      `json:"ready"`
+	PendingUpdate bool      `json:"pendingUpdate"`
+	MissedUpdate  bool      `json:"missedUpdate"`
+	QueueDepth    int       `json:"queueDepth"`
+	LastModified  time.Time `json:"lastModified"`
+	Version       int       `json:"version"`
+	Routes        int       `json:"routes"`
+	DefaultRoutes int       `json:"defaultRoutes"`
+	Expiring      int       `json:"expiring"`
+}
+
+// writeSummary is returned to the mutating requests negotiating JSON.
+type writeSummary struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Deleted int `json:"deleted"`
+}
+
+type routeMeta struct {
+	created    time.Time
+	updated    time.Time
+	expires    time.Time
+	disabled   bool
+	modifiedBy string
+}
+
+type snapshot struct {
+	version int
+	routes  []*eskip.Route
+
+	// etag is the content hash of the routes
+	etag string
 }
 
 type response struct {
-	withContent bool
-	routes      []*eskip.Route
-	err         error
+	withContent     bool
+	routes          []*eskip.Route
+	nextCursor      string
+	etag            string
+	notModified     bool
+	ignoredDefaults []string
+	deletedIDs      []string
+	version         int
+	meta            map[string]routeMeta
+	patched         []patchStatus
+	summary         writeSummary
+	sequence        int
+	delta           bool
+	expanded        map[string][]loopbackTarget
+	traffic         map[string]*jsonTraffic
+	created         bool
+	diff            *versionDiff
+	txnToken        string
+	raw             []byte
+	rawType         string
+	err             error
 }
 
 type request struct {
@@ -66,38 +497,162 @@ type request struct {
 	method   string
 	routes   []*eskip.Route
 	ids      []string
-	accept   responseFormat
-	pretty   bool
-	response chan<- response
+	deltas   []routeDelta
+	rename   string
+	requires string
+	trash    bool
+	restore  string
+
+	// namespace contains the complete ID prefix of the namespace, only the
+	// routes with this prefix are returned or replaced
+	namespace string
+
+	// checkDeltas, when set, validates the routes changed by the deltas with
+	// the checks of the filter that received the request
+	checkDeltas func([]*eskip.Route) error
+
+	diff     bool
+	diffFrom int
+	diffTo   int
+
+	txn      string
+	txnToken string
+
+	// idempotencyKey identifies the retries of the same change
+	idempotencyKey string
+
+	// propagation is set to pause or resume when pausing or resuming the
+	// propagation of the changes to the routing
+	propagation string
+
+	// principal is the user name in the basic authorization of the request
+	principal string
+
+	// createIfMissing is set for individual GET requests, that create the
+	// route in the routes field when it doesn't exist
+	createIfMissing bool
+	body            []byte
+	bodyType        string
+	raw             bool
+	accept          responseFormat
+	pretty          bool
+	expand          bool
+	delta           bool
+	jsonOptions     JSONOptions
+	sections        string
+	analyze         string
+	order           string
+	query           string
+	merge           string
+	backend         string
+	routeMethod     string
+	redact          string
+	limit           int
+	cursor          string
+	ifNone          []string
+	ttl             time.Duration
+	since           int
+	hasSince        bool
+	olderThan       time.Duration
+	strict          bool
+	matchBody       bool
+	previewResult   bool
+	byHash          string
+	variant         string
+	tar             bool
+	withTraffic     bool
+	minimal         bool
+	gzip            bool
+	response        chan<- response
 }
 
 type updateMessage struct {
 	routes     []*eskip.Route
 	deletedIDs []string
-	err        error
+	version    int
+
+	// sequence increases monotonically with every update queued for the
+	// routing
+	sequence int
+
+	err error
+}
+
+type appliedState struct {
+	version   int
+	delivered int
+}
+
+// statusState tells whether the last update delivered to the routing carried an
+// error.
+type statusState struct {
+	Degraded  bool   `json:"degraded"`
+	LastError string `json:"lastError,omitempty"`
 }
 
 type errBadRequest struct{ err error }
 
+// errMissingRoutes lists the IDs not found in a strict delete.
+type errMissingRoutes struct{ ids []string }
+
+// errConflictingRoutes lists the IDs defined differently in the merged
+// documents.
+type errConflictingRoutes struct{ ids []string }
+
+// errInvalidRoutes carries all the problems found in a request document.
+type errInvalidRoutes struct {
+	problems []string
+	json     bool
+
+	// unprocessable is set when the routes are syntactically valid, but
+	// their content is not, e.g. unknown filters or invalid backend
+	unprocessable bool
+}
+
+type errForbidden struct{ err error }
+
+type errTooManyRequests struct{ retryAfter time.Duration }
+
+type statusError interface {
+	StatusCode() int
+}
+
 // SelfRoutes contain route specifications that can be used in the Options as API
 // endpoints for the data client.
-var SelfRoutes = []*eskip.Route{{
-	Id:      DefaultSelfID,
-	Path:    DefaultRoot,
-	Filters: []*eskip.Filter{{Name: Name}},
-	Shunt:   true,
-}, {
-	Id:      DefaultSelfID + "__singleRoute",
-	Path:    DefaultRoot + "/:routeid",
-	Filters: []*eskip.Filter{{Name: Name}},
-	Shunt:   true,
-}}
+var SelfRoutes = selfRoutes(Name)
+
+// selfRoutes returns the default API routes, referencing the config filter by
+// the given name.
+func selfRoutes(filterName string) []*eskip.Route {
+	return []*eskip.Route{{
+		Id:      DefaultSelfID,
+		Path:    DefaultRoot,
+		Filters: []*eskip.Filter{{Name: filterName}},
+		Shunt:   true,
+	}, {
+		Id:      DefaultSelfID + "__singleRoute",
+		Path:    DefaultRoot + "/:routeid",
+		Filters: []*eskip.Filter{{Name: filterName}},
+		Shunt:   true,
+	}, {
+		Id:      DefaultSelfID + "__subresource",
+		Path:    DefaultRoot + "/:routeid/*subpath",
+		Filters: []*eskip.Filter{{Name: filterName}},
+		Shunt:   true,
+	}}
+}
 
 var (
 	errMethodNotSupported   = errors.New("method not supported")
 	errNotFound             = errors.New("not found")
 	errUnsupportedMediaType = errors.New("unsupported media type")
 	errMissedUpdate         = errors.New("missed update")
+	errNotReady             = errors.New("not ready")
+	errGone                 = errors.New("gone")
+	errOverloaded           = errors.New("too many concurrent requests")
+	errConflict             = errors.New("conflict")
+	errUnauthorized         = errors.New("unauthorized")
+	errPreconditionFailed   = errors.New("precondition failed")
 )
 
 func (m updateMessage) hasData() bool {
@@ -116,84 +671,479 @@ func badRequestString(s string) error {
 
 func (e errBadRequest) Error() string { return e.err.Error() }
 
+func (e errInvalidRoutes) Error() string { return strings.Join(e.problems, "; ") }
+
+func (e errMissingRoutes) Error() string {
+	return "routes not found: " + strings.Join(e.ids, ", ")
+}
+
+func (e errConflictingRoutes) Error() string {
+	return "conflicting routes: " + strings.Join(e.ids, ", ")
+}
+
+func forbidden(err error) error {
+	return errForbidden{err}
+}
+
+func (e errForbidden) Error() string { return e.err.Error() }
+
+func (e errTooManyRequests) Error() string { return "too many requests" }
+
+func canonicalHeaders(h http.Header) http.Header {
+	c := make(http.Header)
+	for name, values := range h {
+		c[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+	}
+
+	return c
+}
+
 // New initializes a data client/filter specification for Skipper route
 // configurations.
 func New(o Options) *Spec {
+	if o.FilterName == "" {
+		o.FilterName = Name
+	}
+
 	if len(o.DefaultRoutes) == 0 {
-		o.DefaultRoutes = SelfRoutes
+		o.DefaultRoutes = selfRoutes(o.FilterName)
 	}
 
 	if o.log == nil {
 		o.log = &logging.DefaultLog{}
 	}
 
+	if o.Clock == nil {
+		o.Clock = systemClock{}
+	}
+
+	if o.HistorySize <= 0 {
+		o.HistorySize = defaultHistorySize
+	}
+
+	if o.RetryAttempts <= 0 {
+		o.RetryAttempts = defaultRetryAttempts
+	}
+
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = defaultRetryBackoff
+	}
+
+	if o.MetricsInterval <= 0 {
+		o.MetricsInterval = defaultMetricsInterval
+	}
+
+	if o.TransactionTimeout <= 0 {
+		o.TransactionTimeout = defaultTransactionTimeout
+	}
+
+	if o.IdempotencyTTL <= 0 {
+		o.IdempotencyTTL = defaultIdempotencyTTL
+	}
+
+	if o.IDListSeparator == "" {
+		o.IDListSeparator = defaultIDListSeparator
+	}
+
+	initialRoutes := removeRoutes(uniqueRoutes(o.InitialRoutes), o.DefaultRoutes)
+
 	s := &Spec{
-		defaults: uniqueRoutes(o.DefaultRoutes),
-		log:      o.log,
-		request:  make(chan request),
-		getAll:   make(chan (chan<- updateMessage)),
-		update:   make(chan updateMessage),
-		stop:     make(chan struct{}),
+		name:           o.FilterName,
+		defaults:       uniqueRoutes(o.DefaultRoutes),
+		log:            o.log,
+		clock:          o.Clock,
+		authorize:      o.Authorize,
+		authorizeRts:   o.AuthorizeRoutes,
+		readLimit:      newRateLimiter(o.ReadRateLimit, o.RateLimitByIP, o.TrustForwardedFor),
+		waitReady:      o.WaitForReady,
+		noContent:      o.NoContentOnWrite,
+		protectSelf:    o.ProtectSelfPath,
+		idPrefix:       o.IDPrefix,
+		compact:        o.Compact,
+		override:       o.AllowMethodOverride,
+		keepRaw:        o.KeepRawDocument,
+		onChange:       o.OnChange,
+		retryAttempts:  o.RetryAttempts,
+		retryBackoff:   o.RetryBackoff,
+		metrics:        o.Metrics,
+		gaugeInterval:  o.MetricsInterval,
+		traffic:        o.Traffic,
+		trashSize:      o.TrashSize,
+		trashMaxAge:    o.TrashMaxAge,
+		signingKey:     o.SigningKey,
+		lenient:        o.LenientParse,
+		registry:       o.FilterRegistry,
+		rspHeaders:     canonicalHeaders(o.ResponseHeaders),
+		rejectUnreach:  o.RejectUnreachable,
+		idSeparator:    o.IDListSeparator,
+		jsonOptions:    o.JSONOptions,
+		lowercaseIDs:   o.LowercaseIDs,
+		validateTable:  o.ValidateTable,
+		allowedPreds:   stringSet(o.AllowedPredicates),
+		deniedPreds:    stringSet(o.DeniedPredicates),
+		filterArgs:     o.FilterArgValidators,
+		noopUnchanged:  o.NotModifiedOnNoop,
+		transactions:   make(map[string]*transaction),
+		idempotent:     make(map[string]idempotentResult),
+		idempotencyTTL: o.IdempotencyTTL,
+		dupWindow:      o.DuplicateSuppressionWindow,
+		txnTimeout:     o.TransactionTimeout,
+		meta:           make(map[string]routeMeta),
+		disabled:       make(map[string]bool),
+		reads:          &routeReads{},
+		routes:         initialRoutes,
+		history:        []snapshot{{routes: initialRoutes, etag: routesETag(initialRoutes, nil)}},
+		historySize:    o.HistorySize,
+		started:        o.Clock.Now(),
+		request:        make(chan request),
+		getAll:         make(chan (chan<- updateMessage)),
+		update:         make(chan updateMessage),
+		applied:        make(chan (chan<- appliedState)),
+		status:         make(chan (chan<- statusState)),
+		stats:          make(chan (chan<- statsState)),
+		operations:     newOperationCounters(),
+		table:          make(chan (chan<- []*eskip.Route)),
+		stop:           make(chan struct{}),
+	}
+
+	if o.Debug {
+		s.debug = make(chan (chan<- debugState))
+	}
+
+	if o.MaxConcurrentRequests > 0 {
+		s.inflight = make(chan struct{}, o.MaxConcurrentRequests)
+	}
+
+	if o.MirrorURL != "" {
+		s.mirror = s.mirrorTo(o.MirrorURL)
 	}
 
+	if s.onChange != nil || s.mirror != nil {
+		s.hooks = &hookQueue{signal: make(chan struct{}, 1)}
+		go s.callHooks()
+	}
+
+	s.publishReads()
 	go s.run()
+	if o.PollSource.URL != "" {
+		go s.poll(o.PollSource)
+	}
+
+	if o.SnapshotInterval > 0 && o.SnapshotFunc != nil {
+		go s.export(o.SnapshotInterval, o.SnapshotFunc)
+	}
+
 	return s
 }
 
+// routesMeta returns the metadata of the routes, where the default routes
+// report the start time of the data client.
+func (s *Spec) routesMeta(routes []*eskip.Route) map[string]routeMeta {
+	m := make(map[string]routeMeta)
+	for _, r := range routes {
+		mi, ok := s.meta[r.Id]
+		if !ok {
+			mi = routeMeta{created: s.started, updated: s.started}
+		}
+
+		mi.disabled = s.disabled[r.Id]
+		m[r.Id] = mi
+	}
+
+	return m
+}
+
+func (s *Spec) snapshot(version int) (snapshot, bool) {
+	for _, si := range s.history {
+		if si.version == version {
+			return si, true
+		}
+	}
+
+	return snapshot{}, false
+}
+
+// getChanges returns the routes changed and the IDs of the routes deleted
+// since a version retained in the history.
+func (s *Spec) getChanges(req request) response {
+	if req.since > s.version {
+		return response{err: badRequestString("unknown version")}
+	}
+
+	prev, ok := s.snapshot(req.since)
+	if !ok {
+		return response{err: errGone}
+	}
+
+	_, changed, deleted := replaceRoutes(prev.routes, s.routes)
+	return response{
+		withContent: true,
+		routes:      changed,
+		meta:        s.routesMeta(changed),
+		deletedIDs:  deleted,
+		version:     s.version,
+	}
+}
+
+// getChangesSinceETag returns the changes since the latest version in the
+// history with one of the ETags. When none of the ETags is found, it returns
+// false.
+func (s *Spec) getChangesSinceETag(etags []string) (response, bool) {
+	for i := len(s.history) - 1; i >= 0; i-- {
+		e := s.history[i].etag
+		for _, t := range etags {
+			if contentETag(t) == e {
+				rsp := s.getChanges(request{since: s.history[i].version})
+				rsp.delta = true
+				return rsp, true
+			}
+		}
+	}
+
+	return response{}, false
+}
+
 func (s *Spec) getRoot(req request) response {
+	if req.raw {
+		if s.raw == nil || req.namespace != "" {
+			return response{err: errNotFound}
+		}
+
+		return response{withContent: true, raw: s.raw, rawType: s.rawType, version: s.version}
+	}
+
+	if req.hasSince {
+		return s.getChanges(req)
+	}
+
+	etag := representationETag(routesETag(s.routes, s.disabled), req.namespace, req.variant)
+	for _, t := range req.ifNone {
+		if t == etag || t == "*" {
+			return response{etag: etag, notModified: true}
+		}
+	}
+
+	if req.delta {
+		if rsp, ok := s.getChangesSinceETag(req.ifNone); ok {
+			rsp.etag = etag
+			return rsp
+		}
+	}
+
+	routes := append(append([]*eskip.Route(nil), s.routes...), s.defaults...)
+	if req.namespace != "" {
+		routes = routesWithPrefix(s.routes, req.namespace)
+	}
+
+	// the loopback targets are looked up only in the served routes visible to
+	// the request
+	visible := routes
+	if req.backend != "" {
+		routes = routesByBackend(routes, req.backend)
+	}
+
+	if req.routeMethod != "" {
+		routes = routesByMethod(routes, req.routeMethod)
+	}
+
+	if req.query != "" {
+		routes = routesMatching(routes, req.query)
+	}
+
+	switch req.order {
+	case orderTopological:
+		routes = topologicalOrder(routes)
+	case orderEffective:
+		routes = effectiveOrder(routes)
+	case orderID, orderBackend, orderPredicates:
+		routes = sortRoutes(routes, req.order)
+	}
+
+	var next string
+	switch {
+	case req.limit > 0 && req.order != "":
+		var ok bool
+		if routes, next, ok = orderedPage(routes, req.cursor, req.limit); !ok {
+			return response{err: badRequestString("the route of the cursor is not found")}
+		}
+	case req.limit > 0:
+		routes, next = routesPage(routes, req.cursor, req.limit)
+	}
+
+	var expanded map[string][]loopbackTarget
+	if req.expand {
+		expanded = expandLoopbacks(routes, s.servedRoutes(visible))
+	}
+
 	return response{
 		withContent: true,
-		routes:      append(s.routes, s.defaults...),
+		routes:      routes,
+		meta:        s.routesMeta(routes),
+		nextCursor:  next,
+		etag:        etag,
+		version:     s.version,
+		expanded:    expanded,
 	}
 }
 
-func (s *Spec) putRoot(req request) updateMessage {
-	var update updateMessage
+// changedDefaults returns the IDs of the default routes that the request
+// tried to modify.
+func (s *Spec) changedDefaults(r []*eskip.Route) []string {
+	return routesToIDs(changedRoutes(s.defaults, uniqueRoutes(r)))
+}
+
+func (s *Spec) putRoot(req request) (rsp response, update updateMessage) {
+	rsp.ignoredDefaults = s.changedDefaults(req.routes)
 	routes := uniqueRoutes(req.routes)
 	routes = removeRoutes(routes, s.defaults)
+	if req.namespace != "" {
+		routes = append(removeRoutes(s.routes, routesWithPrefix(s.routes, req.namespace)), routes...)
+	}
+
 	s.routes, update.routes, update.deletedIDs = replaceRoutes(s.routes, routes)
-	return update
+	if s.keepsRaw(req) {
+		s.raw, s.rawType = req.body, req.bodyType
+	}
+
+	return
+}
+
+// keepsRaw tells whether the request document is retained as the raw
+// document. Every other change of the routes invalidates the retained one.
+func (s *Spec) keepsRaw(req request) bool {
+	return s.keepRaw &&
+		req.id == "" &&
+		req.namespace == "" &&
+		(req.method == "PUT" || req.method == "POST")
 }
 
-func (s *Spec) patchInRoot(req request) updateMessage {
-	var update updateMessage
+func (s *Spec) patchInRoot(req request) (rsp response, update updateMessage) {
+	if req.deltas != nil {
+		return s.patchDeltas(req)
+	}
+
+	rsp.ignoredDefaults = s.changedDefaults(req.routes)
 	routes := uniqueRoutes(req.routes)
 	routes = removeRoutes(routes, s.defaults)
 	s.routes, update.routes = upsertRoutes(s.routes, routes)
-	return update
+	return
 }
 
-func (s *Spec) deleteFromRoot(req request) updateMessage {
-	var update updateMessage
+// routesOlderThan returns the routes that were not changed since the cutoff.
+func (s *Spec) routesOlderThan(cutoff time.Time) []*eskip.Route {
+	var routes []*eskip.Route
+	meta := s.routesMeta(s.routes)
+	for _, r := range s.routes {
+		if meta[r.Id].updated.Before(cutoff) {
+			routes = append(routes, r)
+		}
+	}
+
+	return routes
+}
+
+// missingIDs returns the IDs that are found neither among the routes nor among
+// the defaults.
+func (s *Spec) missingIDs(ids []string) []string {
+	var missing []string
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+
+		if len(idsToRoutes([]string{id}, s.tableCopy())) == 0 {
+			missing = append(missing, id)
+		}
+	}
+
+	return missing
+}
+
+func (s *Spec) deleteFromRoot(req request) (rsp response, update updateMessage) {
+	ids := append(req.ids, routesToIDs(req.routes)...)
+	rsp.ignoredDefaults = routesToIDs(uniqueRoutes(idsToRoutes(ids, s.defaults)))
+	if req.strict {
+		if missing := s.missingIDs(ids); len(missing) > 0 {
+			rsp.err = errMissingRoutes{missing}
+			return
+		}
+	}
+
+	if req.matchBody {
+		if len(req.ids) > 0 {
+			rsp.err = badRequestString("matching the body requires routes in eskip format")
+			return
+		}
+
+		if changed := changedRoutes(s.routes, req.routes); len(changed) > 0 {
+			rsp.err = errConflictingRoutes{routesToIDs(changed)}
+			return
+		}
+	}
+
 	routes := idsToRoutes(req.ids, s.routes)
 	routes = append(routes, req.routes...)
+	if req.olderThan > 0 {
+		routes = append(routes, s.routesOlderThan(s.clock.Now().Add(-req.olderThan))...)
+	}
+
 	routes = uniqueRoutes(routes)
 	routes = removeRoutes(routes, s.defaults)
 	routes = removeRoutes(routes, removeRoutes(routes, s.routes))
 	s.routes = removeRoutes(s.routes, routes)
 	update.deletedIDs = routesToIDs(routes)
-	return update
+	return
 }
 
 func (s *Spec) get(req request) response {
-	routes := idsToRoutes([]string{req.id}, append(s.defaults, s.routes...))
+	routes := idsToRoutes([]string{req.id}, s.tableCopy())
 	if len(routes) == 0 {
 		return response{err: errNotFound}
 	}
 
 	return response{
 		routes:      routes,
+		meta:        s.routesMeta(routes),
 		withContent: true,
 	}
 }
 
+// getOrCreate returns a route if it exists, otherwise it creates it from the
+// route in the request.
+func (s *Spec) getOrCreate(req request) (rsp response, update updateMessage) {
+	if rsp = s.get(req); rsp.err != errNotFound {
+		return
+	}
+
+	if s.waitReady && !s.ready {
+		rsp = response{err: errNotReady}
+		return
+	}
+
+	rsp, update = s.put(req)
+	if rsp.err != nil || len(update.routes) == 0 {
+		return
+	}
+
+	rsp.routes = update.routes
+	rsp.withContent = true
+	rsp.created = true
+	return
+}
+
 func (s *Spec) put(req request) (rsp response, update updateMessage) {
 	if len(req.routes) != 1 {
 		rsp = response{err: badRequestString("exactly one route expected")}
 		return
 	}
 
+	if req.requires != "" && len(idsToRoutes([]string{req.requires}, s.tableCopy())) == 0 {
+		rsp = response{err: errConflict}
+		return
+	}
+
 	req.routes[0].Id = req.id
+	rsp.ignoredDefaults = s.changedDefaults(req.routes)
 	routes := removeRoutes(req.routes, s.defaults)
 	if len(routes) == 0 {
 		return
@@ -204,24 +1154,50 @@ func (s *Spec) put(req request) (rsp response, update updateMessage) {
 }
 
 func (s *Spec) patch(req request) (rsp response, update updateMessage) {
-	routes := idsToRoutes([]string{req.id}, append(s.defaults, s.routes...))
+	routes := idsToRoutes([]string{req.id}, s.tableCopy())
 	if len(routes) == 0 {
 		rsp.err = errNotFound
 		return
 	}
 
+	if req.deltas != nil {
+		if len(idsToRoutes([]string{req.id}, s.defaults)) > 0 {
+			rsp.ignoredDefaults = []string{req.id}
+			return
+		}
+
+		if !s.matchesTest(routes[0], req.deltas[0].Test) {
+			rsp.err = errPreconditionFailed
+			return
+		}
+
+		changed := []*eskip.Route{applyDelta(routes[0], req.deltas[0])}
+		if req.checkDeltas != nil {
+			if err := req.checkDeltas(changed); err != nil {
+				rsp.err = err
+				return
+			}
+		}
+
+		s.routes, update.routes = upsertRoutes(s.routes, changed)
+		update.routes = s.applyDisabled(req.deltas, changed, update.routes)
+		return
+	}
+
 	if len(req.routes) != 1 {
 		rsp.err = badRequestString("exactly one route expected")
 		return
 	}
 
+	req.routes[0].Id = req.id
+	rsp.ignoredDefaults = s.changedDefaults(req.routes)
 	routes = removeRoutes(routes, s.defaults)
 	if len(routes) == 0 {
 		return
 	}
 
-	req.routes[0].Id = req.id
-	s.routes, update.routes = upsertRoutes(s.routes, req.routes)
+	merged := mergeRoute(routes[0], req.routes[0])
+	s.routes, update.routes = upsertRoutes(s.routes, []*eskip.Route{merged})
 	return
 }
 
@@ -242,11 +1218,11 @@ func (s *Spec) handleRoot(req request) (rsp response, update updateMessage) {
 	case "HEAD", "GET":
 		rsp = s.getRoot(req)
 	case "PUT", "POST":
-		update = s.putRoot(req)
+		rsp, update = s.putRoot(req)
 	case "PATCH":
-		update = s.patchInRoot(req)
+		rsp, update = s.patchInRoot(req)
 	case "DELETE":
-		update = s.deleteFromRoot(req)
+		rsp, update = s.deleteFromRoot(req)
 	}
 
 	return
@@ -260,7 +1236,11 @@ func (s *Spec) handleIndividual(req request) (response, updateMessage) {
 
 	switch req.method {
 	case "HEAD", "GET":
-		rsp = s.get(req)
+		if req.createIfMissing {
+			rsp, update = s.getOrCreate(req)
+		} else {
+			rsp = s.get(req)
+		}
 	case "PUT", "POST":
 		rsp, update = s.put(req)
 	case "PATCH":
@@ -273,34 +1253,355 @@ func (s *Spec) handleIndividual(req request) (response, updateMessage) {
 }
 
 func (s *Spec) handle(req request) (response, updateMessage) {
+	if s.waitReady && !s.ready && mutatingMethod(req.method) {
+		return response{err: errNotReady}, updateMessage{}
+	}
+
+	if req.trash {
+		return s.handleTrash(req)
+	}
+
+	if req.diff {
+		return s.diffVersions(req), updateMessage{}
+	}
+
+	if req.byHash != "" {
+		return s.getByHash(req), updateMessage{}
+	}
+
+	if req.propagation != "" {
+		return s.setPropagation(req)
+	}
+
+	if req.txn != "" {
+		return s.handleTransaction(req)
+	}
+
 	if req.id == "" {
 		return s.handleRoot(req)
 	}
 
+	if req.rename != "" {
+		return s.rename(req)
+	}
+
 	return s.handleIndividual(req)
 }
 
-func (s *Spec) run() {
-	var (
-		updateRelay  chan<- updateMessage
-		updateToSend updateMessage
-	)
+// rename changes the ID of a route in a single update.
+func (s *Spec) rename(req request) (rsp response, update updateMessage) {
+	if len(idsToRoutes([]string{req.id}, s.defaults)) > 0 {
+		rsp.err = badRequestString("default routes cannot be renamed")
+		return
+	}
+
+	routes := idsToRoutes([]string{req.id}, s.routes)
+	if len(routes) == 0 {
+		rsp.err = errNotFound
+		return
+	}
+
+	if len(idsToRoutes([]string{req.rename}, s.tableCopy())) > 0 {
+		rsp.err = errConflict
+		return
+	}
+
+	renamed := *routes[0]
+	renamed.Id = req.rename
+	s.routes = append(removeRoutes(s.routes, routes), &renamed)
+
+	// the renamed route keeps its state, the commit keeps its creation time
+	// and TTL
+	if m, ok := s.meta[req.id]; ok {
+		s.meta[req.rename] = m
+	}
+
+	if s.disabled[req.id] {
+		s.disabled[req.rename] = true
+	}
+
+	update.routes = []*eskip.Route{&renamed}
+	update.deletedIDs = []string{req.id}
+	return
+}
+
+// expireRoutes removes the routes whose TTL has elapsed.
+func (s *Spec) expireRoutes() updateMessage {
+	now := s.clock.Now()
+
+	var ids []string
+	for id, m := range s.meta {
+		if !m.expires.IsZero() && !now.Before(m.expires) {
+			ids = append(ids, id)
+		}
+	}
 
+	if len(ids) == 0 {
+		return updateMessage{}
+	}
+
+	routes := idsToRoutes(ids, s.routes)
+	s.routes = removeRoutes(s.routes, routes)
+	return updateMessage{deletedIDs: routesToIDs(routes)}
+}
+
+func (s *Spec) scheduleExpiry() {
+	var next time.Time
+	for _, m := range s.meta {
+		if !m.expires.IsZero() && (next.IsZero() || m.expires.Before(next)) {
+			next = m.expires
+		}
+	}
+
+	if next.Equal(s.nextExpiry) {
+		return
+	}
+
+	s.nextExpiry = next
+	if next.IsZero() {
+		s.expiry = nil
+		return
+	}
+
+	s.expiry = s.clock.After(next.Sub(s.clock.Now()))
+}
+
+func (s *Spec) queueUpdate(update updateMessage) {
+	if !update.hasData() {
+		return
+	}
+
+	if s.paused {
+		s.held = mergeUpdates(s.held, update)
+		return
+	}
+
+	s.pending++
+	s.sequence++
+	s.measureQueued()
+	update = s.servedUpdate(update)
+	update.version = s.version
+	update.sequence = s.sequence
+	if s.updateRelay == nil {
+		s.updateRelay = s.update
+		s.updateToSend = update
+		return
+	}
+
+	s.updateToSend = updateMessage{err: errMissedUpdate, version: s.version, sequence: s.sequence}
+}
+
+// validateSwap calls the table validation, when set, for the routes replaced
+// through the root, or changed with the deltas sent to the root.
+func (s *Spec) validateSwap(req request, rsp response, update updateMessage) error {
+	if s.validateTable == nil ||
+		req.id != "" ||
+		req.method != "PUT" && req.method != "POST" && req.deltas == nil ||
+		rsp.err != nil ||
+		!update.hasData() {
+		return nil
+	}
+
+	table := append([]*eskip.Route(nil), s.defaults...)
+	return s.validateTable(append(table, s.servedRoutes(s.routes)...))
+}
+
+// isNoop tells whether a successful change left the routes as they were. The
+// changes with a TTL, the staged ones and the ones reporting the status of the
+// individual patches are not considered as no-op.
+func (s *Spec) isNoop(req request, rsp response, update updateMessage) bool {
+	return mutatingMethod(req.method) &&
+		rsp.err == nil &&
+		!update.hasData() &&
+		req.ttl <= 0 &&
+		req.txn == "" &&
+		rsp.patched == nil
+}
+
+func (s *Spec) recordVersion() {
+	s.version++
+	s.lastModified = s.clock.Now()
+	s.history = append(s.history, snapshot{
+		version: s.version,
+		routes:  append([]*eskip.Route(nil), s.routes...),
+		etag:    routesETag(s.routes, s.disabled),
+	})
+
+	if len(s.history) > s.historySize {
+		s.history = s.history[len(s.history)-s.historySize:]
+	}
+}
+
+// commit maintains the metadata and the version history of the changed
+// routes, and queues the update for the routing. It returns the count of the
+// changes.
+func (s *Spec) commit(req request, update updateMessage) writeSummary {
+	var summary writeSummary
+	now := s.clock.Now()
+	for _, r := range update.routes {
+		m := routeMeta{created: now, updated: now, modifiedBy: req.principal}
+		if prev, ok := s.meta[r.Id]; ok {
+			m.created = prev.created
+			if req.rename != "" {
+				m.expires = prev.expires
+			}
+
+			summary.Updated++
+		} else {
+			summary.Added++
+		}
+
+		s.meta[r.Id] = m
+	}
+
+	summary.Deleted = len(update.deletedIDs)
+
+	if req.ttl > 0 && len(idsToRoutes([]string{req.id}, s.routes)) > 0 {
+		m := s.meta[req.id]
+		m.expires = now.Add(req.ttl)
+		s.meta[req.id] = m
+	}
+
+	for _, id := range update.deletedIDs {
+		delete(s.meta, id)
+		delete(s.disabled, id)
+	}
+
+	if update.hasData() {
+		s.recordVersion()
+		if !s.keepsRaw(req) {
+			s.raw, s.rawType = nil, ""
+		}
+	}
+
+	if update.hasData() || req.ttl > 0 {
+		s.publishReads()
+	}
+
+	s.scheduleExpiry()
+	s.queueUpdate(update)
+	s.notifyChange(update)
+	return summary
+}
+
+func (s *Spec) debugState() debugState {
+	var expiring int
+	for _, m := range s.meta {
+		if !m.expires.IsZero() {
+			expiring++
+		}
+	}
+
+	return debugState{
+		Ready:         s.ready,
+		PendingUpdate: s.updateRelay != nil,
+		MissedUpdate:  s.updateRelay != nil && s.updateToSend.err == errMissedUpdate,
+		QueueDepth:    s.pending,
+		LastModified:  s.lastModified,
+		Version:       s.version,
+		Routes:        len(s.routes),
+		DefaultRoutes: len(s.defaults),
+		Expiring:      expiring,
+	}
+}
+
+func (s *Spec) statusState() statusState {
+	if s.lastErr == nil {
+		return statusState{}
+	}
+
+	return statusState{Degraded: true, LastError: s.lastErr.Error()}
+}
+
+func (s *Spec) run() {
 	for {
 		select {
 		case all := <-s.getAll:
-			all <- updateMessage{routes: s.routes}
-		case updateRelay <- updateToSend:
-			updateRelay = nil
+			table := s.deliveredTable()
+			all <- table
+			s.ready = true
+			s.delivered = table.version
+			s.measureDelivered()
+
+			// loading all the routes recovers from the failed updates
+			s.lastErr = nil
+		case s.updateRelay <- s.updateToSend:
+			s.updateRelay = nil
+			s.pending = 0
+			s.lastErr = s.updateToSend.err
+			s.measureDelivered()
+			s.log.Debugf("update %d delivered", s.updateToSend.sequence)
+
+			// after a missed update, the routing reloads all the routes, and
+			// only that delivers the version
+			if s.updateToSend.err == nil {
+				s.delivered = s.updateToSend.version
+			}
+		case a := <-s.applied:
+			a <- appliedState{version: s.version, delivered: s.delivered}
+		case st := <-s.status:
+			st <- s.statusState()
+		case st := <-s.stats:
+			st <- statsState{routes: len(s.routes), defaults: len(s.defaults), lastModified: s.lastModified}
+		case t := <-s.table:
+			t <- s.tableCopy()
+		case d := <-s.debug:
+			d <- s.debugState()
+		case <-s.pendingTick:
+			s.pendingTick = nil
+			s.measurePending()
+		case <-s.expiry:
+			s.expiry = nil
+			s.nextExpiry = time.Time{}
+			s.commit(request{}, s.expireRoutes())
 		case req := <-s.request:
+			s.commit(request{}, s.expireRoutes())
+			if rsp, ok := s.cachedResult(req); ok {
+				req.response <- rsp
+				continue
+			}
+
+			if req.previewResult {
+				req.response <- s.previewResult(req)
+				continue
+			}
+
+			if s.isDuplicate(req) {
+				req.response <- response{notModified: true, version: s.version}
+				continue
+			}
+
+			prev, prevRaw, prevRawType, prevDisabled := s.routes, s.raw, s.rawType, copyDisabled(s.disabled)
 			rsp, update := s.handle(req)
-			if update.hasData() {
-				if updateRelay == nil {
-					updateRelay = s.update
-					updateToSend = update
-				} else {
-					updateToSend = updateMessage{err: errMissedUpdate}
-				}
+			if err := s.validateSwap(req, rsp, update); err != nil {
+				s.routes, s.raw, s.rawType, s.disabled = prev, prevRaw, prevRawType, prevDisabled
+				rsp = response{err: errInvalidRoutes{problems: []string{err.Error()}, unprocessable: true}}
+				update = updateMessage{}
+			}
+			if req.method == "DELETE" {
+				s.moveToTrash(idsToRoutes(update.deletedIDs, prev))
+			}
+
+			sequence := s.sequence
+			summary := s.commit(req, update)
+			if mutatingMethod(req.method) {
+				rsp.version = s.version
+				rsp.summary = summary
+			}
+
+			if s.sequence != sequence {
+				rsp.sequence = s.sequence
+			}
+
+			if s.noopUnchanged && s.isNoop(req, rsp, update) {
+				rsp.notModified = true
+			}
+
+			s.cacheResult(req, rsp)
+			s.recordDocument(req, rsp)
+
+			if rsp.created {
+				rsp.meta = s.routesMeta(rsp.routes)
 			}
 
 			req.response <- rsp
@@ -316,7 +1617,7 @@ func (s *Spec) LoadAll() ([]*eskip.Route, error) {
 	c := make(chan updateMessage)
 	s.getAll <- c
 	m := <-c
-	return append(s.defaults, m.routes...), m.err
+	return append(append([]*eskip.Route(nil), s.defaults...), m.routes...), m.err
 }
 
 // LoadUpdate returns all changes since the last call to LoadAll or LoadUpdate.
@@ -326,17 +1627,99 @@ func (s *Spec) LoadUpdate() ([]*eskip.Route, []string, error) {
 	return u.routes, u.deletedIDs, u.err
 }
 
-// Name returns the name of the filter in eskip documents ("config").
-// (Skipper's filters.Spec implementation.)
-func (s *Spec) Name() string { return Name }
+// Name returns the name of the filter in eskip documents ("config" by
+// default). (Skipper's filters.Spec implementation.)
+func (s *Spec) Name() string { return s.name }
 
 // CreateFilter creates a config filter. It iscalled by the routing package.
 // (Skipper's filters.Spec implementation.)
-func (s *Spec) CreateFilter(_ []interface{}) (filters.Filter, error) {
+//
+// The filter accepts optional arguments changing its behavior, e.g. to expose
+// different APIs on different self routes: config("readonly") rejects the
+// changes, and config("namespace", "team") serves only the routes in the
+// namespace team, like the /__config/ns/team path.
+func (s *Spec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	f := s.newFilter()
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "readonly":
+			f.readOnly = true
+		case "namespace":
+			if i+1 >= len(args) {
+				return nil, filters.ErrInvalidFilterParameters
+			}
+
+			i++
+			ns, ok := args[i].(string)
+			if !ok || !validID.MatchString(ns) {
+				return nil, filters.ErrInvalidFilterParameters
+			}
+
+			f.idPrefix += ns + namespaceSeparator
+			f.scoped = true
+		default:
+			return nil, filters.ErrInvalidFilterParameters
+		}
+	}
+
+	return f, nil
+}
+
+func (s *Spec) newFilter() *filter {
 	return &filter{
-		request: s.request,
-		log:     s.log,
-	}, nil
+		request:           s.request,
+		log:               s.log,
+		clock:             s.clock,
+		authorize:         s.authorize,
+		authorizeRoutes:   s.authorizeRts,
+		readLimit:         s.readLimit,
+		noContent:         s.noContent,
+		defaults:          s.defaults,
+		protectSelf:       s.protectSelf,
+		idPrefix:          s.idPrefix,
+		compact:           s.compact,
+		override:          s.override,
+		inflight:          s.inflight,
+		debug:             s.debug,
+		applied:           s.applied,
+		status:            s.status,
+		reads:             s.reads,
+		stats:             s.stats,
+		operations:        s.operations,
+		trash:             s.trashSize > 0,
+		signingKey:        s.signingKey,
+		lenient:           s.lenient,
+		registry:          s.registry,
+		responseHeaders:   s.rspHeaders,
+		rejectUnreachable: s.rejectUnreach,
+		idSeparator:       s.idSeparator,
+		jsonOptions:       s.jsonOptions,
+		lowercaseIDs:      s.lowercaseIDs,
+		allowedPreds:      s.allowedPreds,
+		deniedPreds:       s.deniedPreds,
+		filterArgs:        s.filterArgs,
+		traffic:           s.traffic,
+	}
+}
+
+// Handler returns an http.Handler serving the config API outside of Skipper,
+// e.g. for a control plane. It serves the root of the API at / and the
+// individual routes at /<routeid>. To serve the API under a path prefix, wrap
+// the handler with http.StripPrefix. The Spec keeps working as a data client,
+// and Skipper can consume the routes set through the handler.
+func (s *Spec) Handler() http.Handler {
+	f := s.newFilter()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 2)
+		r.Header.Set("X-Config-RouteID", parts[0])
+		if len(parts) > 1 {
+			r.Header.Set("X-Config-Subpath", parts[1])
+		} else {
+			r.Header.Del("X-Config-Subpath")
+		}
+
+		f.ServeHTTP(w, r)
+	})
 }
 
 // Close releases the resource taken by the data client.
@@ -0,0 +1,87 @@
+package configfilter
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+const (
+	formatTar      = "tar"
+	contentTypeTar = "application/x-tar"
+)
+
+func requestTar(method, id, format string) (bool, error) {
+	if format != formatTar {
+		return false, nil
+	}
+
+	if id != "" || method != "PUT" && method != "POST" && method != "PATCH" {
+		return false, badRequestString("tar archives are supported only for importing routes to the root")
+	}
+
+	return true, nil
+}
+
+// parseTar parses the .eskip files in a tar archive, each containing one or
+// more routes. With a merge strategy, every file is merged into the previous
+// ones as an overlay, like the files in multipart content. Other entries are
+// ignored. An archive without .eskip files is rejected, so that an empty or
+// unexpected body doesn't delete all the routes.
+func parseTar(b []byte, strategy string, lenient bool) ([]*eskip.Route, error) {
+	var (
+		routes      []*eskip.Route
+		conflicting []string
+		found       bool
+	)
+
+	t := tar.NewReader(bytes.NewReader(b))
+	for {
+		h, err := t.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, badRequest(err)
+		}
+
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA || path.Ext(h.Name) != ".eskip" {
+			continue
+		}
+
+		found = true
+		d, err := ioutil.ReadAll(t)
+		if err != nil {
+			return nil, badRequest(err)
+		}
+
+		r, _, err := parseContent("PUT", "", "", nil, d, lenient, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if strategy == "" {
+			routes = append(routes, r...)
+			continue
+		}
+
+		var c []string
+		routes, c = mergeDocuments(routes, r, strategy)
+		conflicting = append(conflicting, c...)
+	}
+
+	if !found {
+		return nil, badRequestString("no .eskip files in the tar archive")
+	}
+
+	if strategy == mergeErrorOnConflict && len(conflicting) > 0 {
+		return nil, errConflictingRoutes{ids: conflicting}
+	}
+
+	return routes, nil
+}
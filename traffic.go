@@ -0,0 +1,69 @@
+package configfilter
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RouteTraffic contains the statistics of the live traffic served by a route.
+type RouteTraffic struct {
+
+	// Requests is the number of requests handled by the route.
+	Requests int64
+
+	// Latency is the mean latency of the requests handled by the route.
+	Latency time.Duration
+}
+
+// TrafficProvider returns the traffic statistics of the routes, e.g. taken
+// from the metrics registry of Skipper, by the route ID used in the routing.
+type TrafficProvider interface {
+	RouteTraffic(id string) (RouteTraffic, bool)
+}
+
+// jsonTraffic contains the traffic statistics in JSON, with the latency in
+// milliseconds. The field names are the same in snake_case and camelCase.
+type jsonTraffic struct {
+	Requests int64   `json:"requests"`
+	Latency  float64 `json:"latency"`
+}
+
+func (f *filter) requestWithTraffic(format responseFormat, q url.Values) (bool, error) {
+	switch strings.ToLower(q.Get("withTraffic")) {
+	case "", "false", "0":
+		return false, nil
+	case "true", "1":
+		if f.traffic == nil {
+			return false, badRequestString("no traffic statistics available")
+		}
+
+		if format != responseFormatJSON {
+			return false, badRequestString("the traffic statistics are supported only in JSON")
+		}
+
+		return true, nil
+	default:
+		return false, badRequestString("invalid value of withTraffic")
+	}
+}
+
+// routesTraffic looks up the traffic statistics of the routes in the
+// response, by the IDs used in the routing.
+func (f *filter) routesTraffic(req request, rsp response) map[string]*jsonTraffic {
+	nf := f.namespaced(req.namespace)
+	t := make(map[string]*jsonTraffic)
+	for _, r := range rsp.routes {
+		rt, ok := f.traffic.RouteTraffic(nf.prefixID(r.Id))
+		if !ok {
+			continue
+		}
+
+		t[r.Id] = &jsonTraffic{
+			Requests: rt.Requests,
+			Latency:  float64(rt.Latency) / float64(time.Millisecond),
+		}
+	}
+
+	return t
+}
@@ -0,0 +1,107 @@
+package configfilter
+
+import (
+	"time"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+const trashID = "trash"
+
+// trashedRoute is a deleted route that can be restored.
+type trashedRoute struct {
+	route   *eskip.Route
+	deleted time.Time
+}
+
+// pruneTrash drops the routes exceeding the size or the age bound of the
+// trash.
+func (s *Spec) pruneTrash() {
+	if s.trashMaxAge > 0 {
+		now := s.clock.Now()
+		var kept []trashedRoute
+		for _, t := range s.trash {
+			if now.Sub(t.deleted) < s.trashMaxAge {
+				kept = append(kept, t)
+			}
+		}
+
+		s.trash = kept
+	}
+
+	if len(s.trash) > s.trashSize {
+		s.trash = s.trash[len(s.trash)-s.trashSize:]
+	}
+}
+
+// moveToTrash keeps the deleted routes, replacing the earlier deleted routes
+// with the same ID.
+func (s *Spec) moveToTrash(routes []*eskip.Route) {
+	if s.trashSize <= 0 || len(routes) == 0 {
+		return
+	}
+
+	now := s.clock.Now()
+	for _, r := range routes {
+		s.trash = append(s.trashWithout(r.Id), trashedRoute{route: r, deleted: now})
+	}
+
+	s.pruneTrash()
+}
+
+func (s *Spec) trashWithout(id string) []trashedRoute {
+	var t []trashedRoute
+	for _, ti := range s.trash {
+		if ti.route.Id != id {
+			t = append(t, ti)
+		}
+	}
+
+	return t
+}
+
+// trashedRoutes returns the routes in the trash, in the namespace of the
+// request, when it is set.
+func (s *Spec) trashedRoutes(req request) []*eskip.Route {
+	var routes []*eskip.Route
+	for _, t := range s.trash {
+		routes = append(routes, t.route)
+	}
+
+	if req.namespace != "" {
+		routes = routesWithPrefix(routes, req.namespace)
+	}
+
+	return routes
+}
+
+func (s *Spec) handleTrash(req request) (rsp response, update updateMessage) {
+	s.pruneTrash()
+	routes := s.trashedRoutes(req)
+	if req.restore == "" {
+		rsp.routes = routes
+		rsp.meta = s.routesMeta(routes)
+		rsp.withContent = true
+		return
+	}
+
+	var restored *eskip.Route
+	if r := idsToRoutes([]string{req.restore}, routes); len(r) > 0 {
+		restored = r[0]
+	}
+
+	if restored == nil {
+		rsp.err = errNotFound
+		return
+	}
+
+	if len(idsToRoutes([]string{req.restore}, s.tableCopy())) > 0 {
+		rsp.err = errConflict
+		return
+	}
+
+	s.trash = s.trashWithout(req.restore)
+	s.routes = append(append([]*eskip.Route(nil), s.routes...), restored)
+	update.routes = []*eskip.Route{restored}
+	return
+}
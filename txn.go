@@ -0,0 +1,109 @@
+package configfilter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+const (
+	txnID                     = "txn"
+	defaultTransactionTimeout = 5 * time.Minute
+
+	txnBegin  = "begin"
+	txnStage  = "stage"
+	txnCommit = "commit"
+	txnAbort  = "abort"
+)
+
+// transaction holds the routes staged across multiple requests, until they are
+// committed at once.
+type transaction struct {
+	routes  []*eskip.Route
+	expires time.Time
+}
+
+// requestTransaction parses the path of the transaction requests:
+// /__config/txn/begin, /__config/txn/<token>, /__config/txn/<token>/commit and
+// /__config/txn/<token>/abort.
+func requestTransaction(method, sub string) (op, token string, err error) {
+	if method != "POST" {
+		return "", "", errMethodNotSupported
+	}
+
+	parts := strings.Split(sub, "/")
+	switch {
+	case len(parts) == 1 && parts[0] == txnBegin:
+		return txnBegin, "", nil
+	case len(parts) == 1:
+		return txnStage, parts[0], nil
+	case len(parts) == 2 && (parts[1] == txnCommit || parts[1] == txnAbort):
+		return parts[1], parts[0], nil
+	default:
+		return "", "", errNotFound
+	}
+}
+
+func newTransactionToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (s *Spec) expireTransactions() {
+	now := s.clock.Now()
+	for token, t := range s.transactions {
+		if !now.Before(t.expires) {
+			delete(s.transactions, token)
+		}
+	}
+}
+
+func (s *Spec) handleTransaction(req request) (rsp response, update updateMessage) {
+	s.expireTransactions()
+	if req.txn == txnBegin {
+		token, err := newTransactionToken()
+		if err != nil {
+			rsp.err = err
+			return
+		}
+
+		s.transactions[token] = &transaction{expires: s.clock.Now().Add(s.txnTimeout)}
+		rsp.txnToken = token
+		return
+	}
+
+	t, ok := s.transactions[req.txnToken]
+	if !ok {
+		rsp.err = errNotFound
+		return
+	}
+
+	switch req.txn {
+	case txnStage:
+		rsp.ignoredDefaults = s.changedDefaults(req.routes)
+		t.routes, _ = upsertRoutes(t.routes, removeRoutes(uniqueRoutes(req.routes), s.defaults))
+	case txnAbort:
+		delete(s.transactions, req.txnToken)
+	case txnCommit:
+		delete(s.transactions, req.txnToken)
+		s.routes, update.routes = upsertRoutes(s.routes, t.routes)
+	}
+
+	return
+}
+
+func writeTransactionToken(w http.ResponseWriter, token string) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(struct {
+		Token string `json:"token"`
+	}{token})
+}
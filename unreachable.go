@@ -0,0 +1,119 @@
+package configfilter
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+// methodSets returns the sets of HTTP methods that the route requires, one set
+// for each method predicate.
+func methodSets(r *eskip.Route) []map[string]bool {
+	var sets []map[string]bool
+	if r.Method != "" {
+		sets = append(sets, map[string]bool{strings.ToUpper(r.Method): true})
+	}
+
+	for _, p := range r.Predicates {
+		if p.Name != "Methods" {
+			continue
+		}
+
+		set := make(map[string]bool)
+		for _, a := range p.Args {
+			s, ok := a.(string)
+			if !ok {
+				// unknown arguments, not checked
+				return nil
+			}
+
+			set[strings.ToUpper(s)] = true
+		}
+
+		sets = append(sets, set)
+	}
+
+	return sets
+}
+
+func contradictingMethods(r *eskip.Route) bool {
+	sets := methodSets(r)
+	if len(sets) < 2 {
+		return false
+	}
+
+	for m := range sets[0] {
+		common := true
+		for _, s := range sets[1:] {
+			if !s[m] {
+				common = false
+				break
+			}
+		}
+
+		if common {
+			return false
+		}
+	}
+
+	return true
+}
+
+func staticPath(p string) bool {
+	for _, s := range strings.Split(p, "/") {
+		if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// contradictingPath tells whether a static path cannot match any of the path
+// regular expressions, with or without a trailing slash.
+func contradictingPath(r *eskip.Route) bool {
+	if r.Path == "" || !staticPath(r.Path) {
+		return false
+	}
+
+	for _, pr := range r.PathRegexps {
+		rx, err := regexp.Compile(pr)
+		if err != nil {
+			return false
+		}
+
+		if !rx.MatchString(strings.TrimSuffix(r.Path, "/")) && !rx.MatchString(r.Path+"/") && !rx.MatchString(r.Path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkUnreachable reports the routes whose predicates contradict each other,
+// and so they can never match. The check is conservative: it considers only
+// the method predicates, and the static paths combined with path regular
+// expressions.
+func (f *filter) checkUnreachable(req request) []string {
+	if !f.rejectUnreachable {
+		return nil
+	}
+
+	var problems []string
+	for _, r := range req.routes {
+		id := routeID(req, r)
+		if f.isDefault(id) {
+			continue
+		}
+
+		switch {
+		case contradictingMethods(r):
+			problems = append(problems, "route "+id+" can never match: contradicting method predicates")
+		case contradictingPath(r):
+			problems = append(problems, "route "+id+" can never match: the path does not match the path regexp")
+		}
+	}
+
+	return problems
+}